@@ -75,15 +75,77 @@ under the License.
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
+// reported by getVersion
+const (
+	chaincodeName    = "chaincode"
+	chaincodeVersion = "1.0.0"
+)
+
+// page size used when counting or scanning records page by page
+const countPageSize = 1000
+
+// maximum lengths, in characters, of free-text inputs, so no caller can store oversized records
+const (
+	maxNameLength    = 128
+	maxAddressLength = 256
+	maxPartyLength   = 128 // owner, seller and buyer names
+)
+
+// number of records migrateRecords and deleteAllByType scan per invocation, bounding the
+// transaction's write set
+const migrateBatchSize = 500
+
+// private data collection holding the deposits of conditions created by initConditionPrivate
+const depositCollection = "depositCollection"
+
+// composite key object type of the chaincodeConfig record; composite keys stay out of range scans
+const configObjectType = "config"
+
+// defaultConfig applies until setConfig is called, and to any field the stored config leaves out.
+// It returns a fresh value each time because json.Unmarshal reuses the backing array of slices.
+func defaultConfig() chaincodeConfig {
+	return chaincodeConfig{
+		MaxDeposit:      0,
+		ListingRoles:    []string{"agent", "admin"},
+		MaxEventSize:    64 * 1024,
+		MaxQueryResults: 10000,
+		DocTypes:        append([]string(nil), validDocTypes...),
+	}
+}
+
+// certificate attribute holding the invoker's role, e.g. "admin"
+const roleAttribute = "role"
+
+// statuses a contract moves through
+var validContractStatuses = []string{"pending", "signed", "completed", "cancelled"}
+
+// ISO 4217 currency codes a deposit may be given in
+var validCurrencies = []string{"KRW", "USD", "EUR", "JPY", "CNY"}
+
+// currency that conditions stored before currencies existed are totalled under
+const unspecifiedCurrency = "UNSPECIFIED"
+
+// docTypes of the records this chaincode stores
+var validDocTypes = []string{"property", "condition", "contract"}
+
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
 }
@@ -94,7 +156,21 @@ type property struct {
 	Property_num		string `json:"property_num"`    //the fieldtags are needed to keep case from bouncing around
 	Name						string `json:"name"`
 	Address					string `json:"address"`
-	Owner						string    `json:"owner"`
+	Owner						string    `json:"owner"`     //display form, as given by the caller
+	OwnerKey					string `json:"owner_key"` //normalized lowercase form used for matching and queries
+	Owners						[]string `json:"owners,omitempty"` //normalized keys of every co-owner, owner_key first; empty when solely owned
+	CreatedAt					string `json:"createdAt"` //RFC3339 transaction timestamp
+	UpdatedAt					string `json:"updatedAt"` //RFC3339 timestamp of the last write
+	Version						int `json:"version"` //incremented on every write, for optimistic concurrency
+	SaleHistory				[]saleRecord `json:"saleHistory,omitempty"` //appended by sellProperty, oldest first
+	OwnerMSP					string `json:"owner_msp,omitempty"` //MSP ID the owners are enrolled with, the lister's; empty on older records
+}
+
+// 매매 기록
+type saleRecord struct {
+	Buyer     string `json:"buyer"`
+	Price     int    `json:"price"`
+	Timestamp string `json:"timestamp"` //RFC3339 transaction timestamp of the sale
 }
 
 // 계약 조건
@@ -104,7 +180,19 @@ type conditionOfContract struct {
 	Property_num			string `json:"property_num"`
 	Seller						string `json:"seller"`
   Buyer							string `json:"buyer"`
-  Deposit						int `json:"deposit"`
+  Deposit						int `json:"deposit,omitempty"` //left out when the deposit is private
+	Currency					string `json:"currency"` //ISO 4217 code of the deposit, one of validCurrencies
+	CreatedAt					string `json:"createdAt"` //RFC3339 transaction timestamp
+	UpdatedAt					string `json:"updatedAt"` //RFC3339 timestamp of the last write
+	DepositPrivate		bool `json:"depositPrivate,omitempty"` //deposit is kept in depositCollection
+	DepositPaid				bool `json:"depositPaid,omitempty"` //set by the buyer via markDepositPaid
+}
+
+// 비공개 보증금 (depositCollection 저장용)
+type conditionDeposit struct {
+	ObjectType    string `json:"docType"`
+	Condition_num string `json:"condition_num"`
+	Deposit       int    `json:"deposit"`
 }
 
 // 계약서
@@ -112,6 +200,169 @@ type contract struct {
 	ObjectType				string `json:"docType"` //docType is used to distinguish the various types of objects in state database
 	Contract_num			string `json:"contract_num"`    //the fieldtags are needed to keep case from bouncing around
 	Condition_num			string `json:"condition_num"`
+	Status						string `json:"status"` //pending, signed, completed or cancelled
+	CreatedAt					string `json:"createdAt"` //RFC3339 transaction timestamp
+	UpdatedAt					string `json:"updatedAt"` //RFC3339 timestamp of the last write
+	Approvals					map[string]bool `json:"approvals,omitempty"` //seller and buyer who approved via approveContract
+	AmendmentHistory	[]string `json:"amendmentHistory,omitempty"` //conditions replaced by amendContract, oldest first
+	Legacy						map[string]interface{} `json:"legacy,omitempty"` //fields of a condition-shaped record kept by repairContractRecords
+}
+
+// repairContractRecords 결과
+type repairReport struct {
+	Repaired     []string `json:"repaired"` //in a dry run, the records that would be repaired
+	Unrepairable []string `json:"unrepairable"`
+	DryRun       bool     `json:"dryRun,omitempty"`
+	NextKey      string   `json:"nextKey"` //key to continue the repair from, empty once every key was scanned
+}
+
+// 계약 해지 결과 (반환할 보증금)
+type cancellation struct {
+	Contract_num    string `json:"contract_num"`
+	Condition_num   string `json:"condition_num"`
+	ReleasedDeposit int    `json:"releasedDeposit"`
+	Currency        string `json:"currency"`
+	DepositPrivate  bool   `json:"depositPrivate,omitempty"` //releasedDeposit was read from depositCollection, or is 0 if this peer holds no copy
+}
+
+// 보증금 변경 결과
+type depositChange struct {
+	Condition_num string `json:"condition_num"`
+	OldDeposit    int    `json:"oldDeposit"`
+	NewDeposit    int    `json:"newDeposit"`
+}
+
+// 참조가 끊긴 레코드 (getOrphanedRecords 결과)
+type orphanReport struct {
+	OrphanedConditions []string `json:"orphanedConditions"` //conditions whose property does not exist
+	OrphanedContracts  []string `json:"orphanedContracts"`  //contracts whose condition does not exist
+}
+
+// 계약서 - 계약 조건 - 매물 전체 묶음
+type contractChain struct {
+	Contract  contract            `json:"contract"`
+	Condition conditionOfContract `json:"condition"`
+	Property  property            `json:"property"`
+}
+
+// 계약 조건이 포함된 계약서
+type contractWithCondition struct {
+	contract
+	Condition *conditionOfContract `json:"condition"`
+	Note      string               `json:"note,omitempty"`
+}
+
+// 계약 진행 상태가 포함된 계약 조건
+type conditionWithContractStatus struct {
+	conditionOfContract
+	ContractStatus string `json:"contractStatus"` //"none" until a contract is created from the condition
+}
+
+// 당사자 역할이 포함된 계약서
+type partyContract struct {
+	contract
+	Role string `json:"role"` //"seller", "buyer", or "both" when the party is on each side of the condition
+}
+
+// 매물별 보증금 합계
+type depositAggregate struct {
+	Property_num string         `json:"property_num"`
+	OfferCount   int            `json:"offerCount"`
+	TotalDeposit map[string]int `json:"totalDeposit"` //per currency
+	Note         string         `json:"note,omitempty"` //set when private deposits this peer cannot read are left out
+}
+
+// 소유자별 시장 통계
+type marketStats struct {
+	Owner                string `json:"owner"`
+	PropertyCount        int    `json:"propertyCount"`
+	ActiveConditionCount int    `json:"activeConditionCount"`
+	TotalDeposit         map[string]int `json:"totalDeposit"` //per currency
+}
+
+// 처리된 소유권 이전 (transferId 재전송 확인용)
+type processedTransfer struct {
+	Property_num string `json:"property_num"`
+	NewOwner     string `json:"newOwner"`
+	Invoker      string `json:"invoker"` //see invokerID
+}
+
+// 처리된 요청 (idempotencyKey 재전송 확인용)
+type processedRequest struct {
+	Function string `json:"function"`
+	ArgsHash string `json:"argsHash"`
+	Invoker  string `json:"invoker"` //MSP ID and client ID of the first caller, see invokerID
+	Result   []byte `json:"result"`
+}
+
+// 복합 키를 나눈 결과
+type compositeKeyEntry struct {
+	ObjectType string   `json:"objectType"`
+	Attributes []string `json:"attributes"`
+}
+
+// 채널 단위 설정 (setConfig로 변경)
+type chaincodeConfig struct {
+	MaxDeposit      int      `json:"maxDeposit"`      //largest deposit a condition may carry, 0 for no limit
+	ListingRoles    []string `json:"listingRoles"`    //roles allowed to list properties
+	MaxEventSize    int      `json:"maxEventSize"`    //largest event payload in bytes before setEvent sends a stub
+	MaxQueryResults int      `json:"maxQueryResults"` //most records a non-paginated query returns before failing with TOO_MANY_RESULTS
+	DocTypes        []string `json:"docTypes"`        //docTypes the handlers taking a docType accept, a subset of validDocTypes
+}
+
+// 마지막 수정 시각이 포함된 매물
+type modifiedProperty struct {
+	Key          string   `json:"Key"`
+	LastModified string   `json:"LastModified"`
+	Record       property `json:"Record"`
+}
+
+// 키 단위 보증 정책
+type keyEndorsementPolicy struct {
+	Key  string   `json:"key"`
+	Orgs []string `json:"orgs"` //MSP IDs whose peers must all endorse changes, empty for the chaincode policy
+}
+
+// 소유 기간 통계
+type ownershipDurationStats struct {
+	Property_num       string             `json:"property_num"`
+	Holdings           []ownershipHolding `json:"holdings"`
+	Transfers          int                `json:"transfers"`
+	AverageHoldSeconds int64              `json:"averageHoldSeconds"`
+}
+
+// 소유자별 소유 기간
+type ownershipHolding struct {
+	Owner           string `json:"owner"` //owner key; each co-owner has holdings of their own
+	From            string `json:"from"`
+	DurationSeconds int64  `json:"durationSeconds"`
+	Current         bool   `json:"current"`
+}
+
+// 소유자 변경 이력
+type ownerChange struct {
+	TxId      string   `json:"TxId"`
+	Timestamp string   `json:"Timestamp"`
+	Owner     string   `json:"Owner"`
+	Owners    []string `json:"Owners,omitempty"` //owner keys after the change, when co-owned
+	IsDelete  bool     `json:"IsDelete,omitempty"`
+}
+
+// 계약서 상태 변경 이력
+type contractRevision struct {
+	TxId       string    `json:"TxId"`
+	Timestamp  string    `json:"Timestamp"`
+	Status     string    `json:"Status"`
+	Transition string    `json:"Transition"`
+	IsDelete   bool      `json:"IsDelete"`
+	Value      *contract `json:"Value"`
+}
+
+// 이력 조회용 리비전
+type historyRevision struct {
+	timestamp time.Time
+	owners    []string //owner keys
+	isDelete  bool
 }
 
 
@@ -125,6 +376,186 @@ func main() {
 	}
 }
 
+// invokeHandler is one entry of the Invoke dispatch table
+type invokeHandler struct {
+	fn      func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string) pb.Response
+	args    int  // expected number of arguments
+	minArgs bool // args is a minimum, further optional arguments are allowed
+}
+
+// invokeHandlers maps each function name to its handler and arity.
+// Invoke checks the argument count before calling the handler.
+var invokeHandlers = map[string]invokeHandler{
+	"initProperty":                       {(*SimpleChaincode).initProperty, 4, false},
+	"initPropertyBatch":                  {(*SimpleChaincode).initPropertyBatch, 1, false},
+	"initConditon":                       {(*SimpleChaincode).initConditon, 6, false},
+	"CreateContract":                     {(*SimpleChaincode).CreateContract, 2, false},
+	"deleteProperty":                     {(*SimpleChaincode).deleteProperty, 1, false},
+	"signContract":                       {(*SimpleChaincode).signContract, 1, false},
+	"cancelContract":                     {(*SimpleChaincode).cancelContract, 1, false},
+	"completeContract":                   {(*SimpleChaincode).completeContract, 1, false},
+	"updateDeposit":                      {(*SimpleChaincode).updateDeposit, 2, false},
+	"transferProperty":                   {(*SimpleChaincode).transferProperty, 2, true},
+	"transferPropertiesBasedOnOwner":     {(*SimpleChaincode).transferPropertiesBasedOnOwner, 2, true},
+	"getVersion":                         {(*SimpleChaincode).getVersion, 0, false},
+	"readValue":                          {(*SimpleChaincode).readValue, 1, true},
+	"readProperty":                       {(*SimpleChaincode).readProperty, 1, true},
+	"repairContractRecords":              {(*SimpleChaincode).repairContractRecords, 0, true},
+	"getAggregateDepositByProperty":      {(*SimpleChaincode).getAggregateDepositByProperty, 1, false},
+	"getOwnershipDurationStats":          {(*SimpleChaincode).getOwnershipDurationStats, 1, false},
+	"getHistoryForProperty":              {(*SimpleChaincode).getHistoryForProperty, 1, true},
+	"getPropertyOwnerHistory":            {(*SimpleChaincode).getPropertyOwnerHistory, 1, true},
+	"getPropertiesByRange":               {(*SimpleChaincode).getPropertiesByRange, 2, true},
+	"getPropertiesByRangeWithPagination": {(*SimpleChaincode).getPropertiesByRangeWithPagination, 4, true},
+	"getPropertiesByOwnerIndexed":        {(*SimpleChaincode).getPropertiesByOwnerIndexed, 1, true},
+	"queryPropertiesByOwner":             {(*SimpleChaincode).queryPropertiesByOwner, 1, true},
+	"queryProperties":                    {(*SimpleChaincode).queryProperties, 1, true},
+	"queryPropertiesWithPagination":      {(*SimpleChaincode).queryPropertiesWithPagination, 3, true},
+	"getAllRecordsByType":                {(*SimpleChaincode).getAllRecordsByType, 1, true},
+	"queryConditionsByDepositRange":      {(*SimpleChaincode).queryConditionsByDepositRange, 2, true},
+	"getConditionsByProperty":            {(*SimpleChaincode).getConditionsByProperty, 1, true},
+	"getContractForCondition":            {(*SimpleChaincode).getContractForCondition, 1, true},
+	"getContractChain":                   {(*SimpleChaincode).getContractChain, 1, false},
+	"deleteAllByType":                    {(*SimpleChaincode).deleteAllByType, 2, true},
+	"getRecordCountByType":               {(*SimpleChaincode).getRecordCountByType, 1, false},
+	"getPropertiesByAddressPrefix":       {(*SimpleChaincode).getPropertiesByAddressPrefix, 1, false},
+	"updateProperty":                     {(*SimpleChaincode).updateProperty, 2, true},
+	"readContract":                       {(*SimpleChaincode).readContract, 1, false},
+	"addOwner":                           {(*SimpleChaincode).addOwner, 2, false},
+	"removeOwner":                        {(*SimpleChaincode).removeOwner, 2, false},
+	"getConditionsBySeller":              {(*SimpleChaincode).getConditionsBySeller, 1, false},
+	"getConditionsByBuyer":               {(*SimpleChaincode).getConditionsByBuyer, 1, false},
+	"migrateRecords":                     {(*SimpleChaincode).migrateRecords, 1, true},
+	"getMarketStatsByOwner":              {(*SimpleChaincode).getMarketStatsByOwner, 1, false},
+	"initConditionPrivate":               {(*SimpleChaincode).initConditionPrivate, 5, false},
+	"readConditionPrivate":               {(*SimpleChaincode).readConditionPrivate, 1, false},
+	"getConditionDepositHash":            {(*SimpleChaincode).getConditionDepositHash, 1, false},
+	"getByPartialCompositeKey":           {(*SimpleChaincode).getByPartialCompositeKey, 1, true},
+	"setConfig":                          {(*SimpleChaincode).setConfig, 1, false},
+	"getConfig":                          {(*SimpleChaincode).getConfig, 0, false},
+	"getPropertiesModifiedSince":         {(*SimpleChaincode).getPropertiesModifiedSince, 3, false},
+	"deleteCondition":                    {(*SimpleChaincode).deleteCondition, 1, false},
+	"deleteContract":                     {(*SimpleChaincode).deleteContract, 1, false},
+	"approveContract":                    {(*SimpleChaincode).approveContract, 1, false},
+	"getPendingApprovalsForParty":        {(*SimpleChaincode).getPendingApprovalsForParty, 1, false},
+	"getHistoryForContract":              {(*SimpleChaincode).getHistoryForContract, 1, false},
+	"readValues":                         {(*SimpleChaincode).readValues, 1, true},
+	"queryContractsByStatus":             {(*SimpleChaincode).queryContractsByStatus, 1, false},
+	"validateProperty":                   {(*SimpleChaincode).validateProperty, 4, false},
+	"reindexOwners":                      {(*SimpleChaincode).reindexOwners, 0, true},
+	"markDepositPaid":                    {(*SimpleChaincode).markDepositPaid, 1, false},
+	"setKeyEndorsementPolicy":            {(*SimpleChaincode).setKeyEndorsementPolicy, 2, true},
+	"getKeyEndorsementPolicy":            {(*SimpleChaincode).getKeyEndorsementPolicy, 1, false},
+	"searchProperties":                   {(*SimpleChaincode).searchProperties, 1, false},
+	"createPropertyAuto":                 {(*SimpleChaincode).createPropertyAuto, 3, false},
+	"createConditionAuto":                {(*SimpleChaincode).createConditionAuto, 5, false},
+	"createContractAuto":                 {(*SimpleChaincode).createContractAuto, 1, false},
+	"getConditionWithContractStatus":     {(*SimpleChaincode).getConditionWithContractStatus, 1, false},
+	"getContractsInvolvingParty":         {(*SimpleChaincode).getContractsInvolvingParty, 1, false},
+	"sellProperty":                       {(*SimpleChaincode).sellProperty, 3, false},
+	"amendContract":                      {(*SimpleChaincode).amendContract, 2, false},
+	"getOrphanedRecords":                 {(*SimpleChaincode).getOrphanedRecords, 0, false},
+}
+
+// idempotentHandlers accept one optional trailing idempotencyKey argument on top of their
+// arity. A retried invoke with the same key gets the original result instead of running again.
+var idempotentHandlers = map[string]bool{
+	"initProperty":      true,
+	"initPropertyBatch": true,
+	"initConditon":      true,
+	"CreateContract":    true,
+}
+
+// ndjsonHandlers return a JSON array of records and accept one optional trailing
+// {"format":"ndjson"} argument on top of their arguments. It returns the same records as
+// newline-delimited JSON, one per line, so clients can process large results incrementally.
+var ndjsonHandlers = map[string]bool{
+	"getPropertiesByRange":          true,
+	"getPropertiesByOwnerIndexed":   true,
+	"queryPropertiesByOwner":        true,
+	"queryProperties":               true,
+	"getAllRecordsByType":           true,
+	"queryConditionsByDepositRange": true,
+	"getConditionsByProperty":       true,
+	"getConditionsBySeller":         true,
+	"getConditionsByBuyer":          true,
+	"getPropertiesByAddressPrefix":  true,
+	"queryContractsByStatus":        true,
+	"searchProperties":              true,
+	"getHistoryForProperty":         true,
+}
+
+// parseFormatOption reads a trailing {"format":"..."} argument of an ndjsonHandlers query,
+// reporting whether arg is one.
+func parseFormatOption(arg string) (string, bool) {
+	var option struct {
+		Format *string `json:"format"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(arg))
+	decoder.DisallowUnknownFields()
+	if decoder.Decode(&option) != nil || option.Format == nil {
+		return "", false
+	}
+	return *option.Format, true
+}
+
+// invokeIdempotent runs handler at most once per idempotencyKey. Processed keys are kept under
+// "idempotency~key" composite keys, apart from business data, together with the function,
+// a hash of its arguments, the invoker and the result returned the first time. Only successful
+// results are kept, so a failed invoke can be retried with the same key. A replay is only
+// answered for the invoker who made the original request; the handler's own role and owner
+// checks never ran for anyone else.
+func (t *SimpleChaincode) invokeIdempotent(stub shim.ChaincodeStubInterface, function string, handler invokeHandler, args []string, idempotencyKey string) pb.Response {
+	if len(idempotencyKey) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "idempotencyKey must be a non-empty string")
+	}
+	idemKey, err := stub.CreateCompositeKey("idempotency~key", []string{idempotencyKey})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	argsAsBytes, _ := json.Marshal(args)
+	argsHash := sha256.Sum256(argsAsBytes)
+	invoker, err := invokerID(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	request := processedRequest{Function: function, ArgsHash: hex.EncodeToString(argsHash[:]), Invoker: invoker}
+
+	processedAsBytes, err := stub.GetState(idemKey)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get idempotencyKey:" + err.Error())
+	} else if processedAsBytes != nil {
+		processed := processedRequest{}
+		err = json.Unmarshal(processedAsBytes, &processed)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		if processed.Invoker != request.Invoker {
+			return errorJSON(errCodeUnauthorized, "idempotencyKey " + idempotencyKey + " was already used by another invoker")
+		}
+		if processed.Function != request.Function || processed.ArgsHash != request.ArgsHash {
+			return errorJSON(errCodeInvalidArgs, "idempotencyKey " + idempotencyKey + " was already used for a different request")
+		}
+		fmt.Println("- " + function + " replayed idempotencyKey " + idempotencyKey)
+		return shim.Success(processed.Result)
+	}
+
+	response := handler.fn(t, stub, args)
+	if response.Status != shim.OK {
+		return response
+	}
+	request.Result = response.Payload
+	processedAsBytes, err = json.Marshal(request)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.PutState(idemKey, processedAsBytes) //remember the idempotencyKey
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return response
+}
+
 // Init initializes chaincode
 // ===========================
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
@@ -138,232 +569,5070 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("invoke is running " + function)
 
 	// Handle different functions
-	if function == "initProperty" {
-		return t.initProperty(stub, args)
-	} else if function == "initConditon" {
-		return t.initConditon(stub, args)
-	} else if function == "CreateContract" {
-		return t.CreateContract(stub, args)
-	} else if function == "transferProperty" {
-		return t.transferProperty(stub, args)
-	} else if function == "readValue" {
-		return t.readValue(stub, args)
+	handler, ok := invokeHandlers[function]
+	if !ok {
+		fmt.Println("invoke did not find func: " + function) //error
+		return errorJSON(errCodeInvalidArgs, "Received unknown function invocation")
 	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+	if idempotentHandlers[function] && len(args) == handler.args+1 {
+		return t.invokeIdempotent(stub, function, handler, args[:handler.args], args[handler.args])
+	}
+	format := ""
+	if ndjsonHandlers[function] && len(args) > handler.args {
+		if option, ok := parseFormatOption(args[len(args)-1]); ok {
+			format, args = option, args[:len(args)-1]
+		}
+	}
+	if format != "" && format != "json" && format != "ndjson" {
+		return errorJSON(errCodeInvalidArgs, "Unknown format " + format + ". Expecting json or ndjson")
+	}
+	if handler.minArgs && len(args) < handler.args {
+		return errorJSON(errCodeInvalidArgs, fmt.Sprintf("%s expects at least %d arguments, got %d", function, handler.args, len(args)))
+	} else if !handler.minArgs && len(args) != handler.args {
+		return errorJSON(errCodeInvalidArgs, fmt.Sprintf("%s expects %d arguments, got %d", function, handler.args, len(args)))
+	}
+	response := handler.fn(t, stub, args)
+	if format == "ndjson" && response.Status == shim.OK {
+		ndjsonAsBytes, err := ndjsonFromJSONArray(response.Payload)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		return shim.Success(ndjsonAsBytes)
+	}
+	return response
 }
 
 // ============================================================
 // initProperty
+// Returns the key the property was stored under, i.e. the canonical property_num.
+// Fires a "PropertyCreated" event carrying the stored JSON. Fabric keeps only the
+// last SetEvent of a transaction, so this must stay the only event it sets.
 // ============================================================
 func (t *SimpleChaincode) initProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
 	// propertyNum, propertyName, address, owner
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
+	// owner may list co-owners, comma-separated ("kim,lee") or as a JSON array (["kim","lee"])
 
-	// ==== Input sanitation ====
-	fmt.Println("- start init marble")
-	if len(args[0]) <= 0 {
-		return shim.Error("1st argument must be a non-empty string")
+	// ==== Only agents and admins, or the configured listingRoles, may list properties ====
+	config, err := loadConfig(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
 	}
-	if len(args[1]) <= 0 {
-		return shim.Error("2nd argument must be a non-empty string")
+	err = requireRole(stub, config.ListingRoles...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
 	}
-	if len(args[2]) <= 0 {
-		return shim.Error("3rd argument must be a non-empty string")
+
+	// ==== Input sanitation ====
+	fmt.Println("- start init property")
+	property, errCode, err := checkNewProperty(stub, args)
+	if err != nil {
+		return errorJSON(errCode, err.Error())
 	}
-	if len(args[3]) <= 0 {
-		return shim.Error("4th argument must be a non-empty string")
+	propertyNum := property.Property_num
+	property.CreatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
 	}
+	property.UpdatedAt = property.CreatedAt
 
-	// property
-	propertyNum := strings.ToLower(args[0])
-	propertyName := strings.ToLower(args[1])
-	address := strings.ToLower(args[2])
-	owner := strings.ToLower(args[3])
+	// ==== Owners are enrolled with the listing agent's organization ====
+	property.OwnerMSP, err = cid.GetMSPID(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
 
-	// ==== Create property object and marshal to JSON ====
-	objectType := "property"
-	property := &property{objectType, propertyNum, propertyName, address, owner}
+	// ==== Marshal property object to JSON ====
 	propertyJSONasBytes, err := json.Marshal(property)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorJSON(errCodeInternal, err.Error())
 	}
 	// === Save object to state ===
 	err = stub.PutState(propertyNum, propertyJSONasBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	//  ==== Index the property by owner to enable owner-based range queries, e.g. return all tom's properties ====
+	for _, ownerKey := range ownerKeysOf(*property) {
+		err = putOwnerIndex(stub, ownerKey, propertyNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+	}
+
+	// ==== Emit PropertyCreated with the stored object as payload ====
+	err = setEvent(stub, "PropertyCreated", propertyNum, "property", propertyJSONasBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
 	}
 
 	// ==== Return success ====
 	fmt.Println("- end init Property")
-	return shim.Success(nil)
+	return shim.Success([]byte(propertyNum))
 }
 
-// ============================================================
-// initConditon
-// ============================================================
-func (t *SimpleChaincode) initConditon(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
-	// conditionNum, propertyNum, seller, buyer, deposit
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 5")
-	}
-
-	// ==== Input sanitation ====
-	fmt.Println("- start init condition")
+// validatePropertyArgs checks the propertyNum, propertyName, address, owner arguments of
+// initProperty and builds the normalized property they describe.
+func validatePropertyArgs(args []string) (*property, error) {
 	if len(args[0]) <= 0 {
-		return shim.Error("1st argument must be a non-empty string")
+		return nil, fmt.Errorf("1st argument must be a non-empty string")
 	}
 	if len(args[1]) <= 0 {
-		return shim.Error("2nd argument must be a non-empty string")
+		return nil, fmt.Errorf("2nd argument must be a non-empty string")
 	}
 	if len(args[2]) <= 0 {
-		return shim.Error("3rd argument must be a non-empty string")
+		return nil, fmt.Errorf("3rd argument must be a non-empty string")
 	}
 	if len(args[3]) <= 0 {
-		return shim.Error("4th argument must be a non-empty string")
-	}
-	if len(args[4]) <= 0 {
-		return shim.Error("5th argument must be a non-empty string")
+		return nil, fmt.Errorf("4th argument must be a non-empty string")
 	}
 
-	// condition
-	conditionNum := strings.ToLower(args[0])
-	propertyNum := strings.ToLower(args[1])
-	seller := strings.ToLower(args[2])
-	buyer := strings.ToLower(args[3])
-	deposit, err :=strconv.Atoi(args[4])
+	// property
+	propertyNo, err := parsePositiveInt("property_num", args[0])
 	if err != nil {
-		return shim.Error("5th argument must be a numeric string")
+		return nil, err
 	}
-
-	// ==== Create condition object and marshal to JSON ====
-	objectType := "condition"
-	condition := &conditionOfContract{objectType, conditionNum, propertyNum, seller, buyer, deposit}
-	conditionJSONasBytes, err := json.Marshal(condition)
-	if err != nil {
-		return shim.Error(err.Error())
+	propertyNum := strconv.Itoa(propertyNo)
+	propertyName := strings.ToLower(args[1])
+	if err = checkLength("name", propertyName, maxNameLength); err != nil {
+		return nil, err
 	}
-
-	// === Save object to state ===
-	err = stub.PutState(conditionNum, conditionJSONasBytes)
+	// collapse runs of whitespace so equality queries on address stay reliable
+	address := strings.ToLower(strings.Join(strings.Fields(args[2]), " "))
+	if len(address) <= 0 {
+		return nil, fmt.Errorf("3rd argument must not be blank")
+	}
+	if err = checkLength("address", address, maxAddressLength); err != nil {
+		return nil, err
+	}
+	owners, err := parseOwners(args[3])
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, err
 	}
 
-	// ==== Return success ====
-	fmt.Println("- end init contract condition")
-	return shim.Success(nil)
+	objectType := "property"
+	newProperty := &property{
+		ObjectType:   objectType,
+		Property_num: propertyNum,
+		Name:         propertyName,
+		Address:      address,
+		Version:      1,
+	}
+	applyOwners(newProperty, owners)
+	return newProperty, nil
 }
 
-// ============================================================
-// CreateContract
-// ============================================================
-func (t *SimpleChaincode) CreateContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
-	// contractNum, conditionNum
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+// parseOwners splits an owner argument into its owners. Co-owners are given comma-separated
+// or as a JSON array; a name that itself contains a comma needs the JSON array form.
+func parseOwners(value string) ([]string, error) {
+	var names []string
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		err := json.Unmarshal([]byte(value), &names)
+		if err != nil {
+			return nil, fmt.Errorf("owners must be a JSON array of strings: %s", err)
+		}
+	} else {
+		names = strings.Split(value, ",")
 	}
 
-	// ==== Input sanitation ====
-	fmt.Println("- start init condition")
-	if len(args[0]) <= 0 {
-		return shim.Error("1st argument must be a non-empty string")
+	owners := make([]string, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		owner := strings.TrimSpace(name)
+		if len(owner) <= 0 {
+			return nil, fmt.Errorf("owner names must be non-empty strings")
+		}
+		if err := checkLength("owner", owner, maxPartyLength); err != nil {
+			return nil, err
+		}
+		if seen[strings.ToLower(owner)] {
+			return nil, fmt.Errorf("owner %s is listed more than once", owner)
+		}
+		seen[strings.ToLower(owner)] = true
+		owners = append(owners, owner)
 	}
-	if len(args[1]) <= 0 {
-		return shim.Error("2nd argument must be a non-empty string")
+	if len(owners) <= 0 {
+		return nil, fmt.Errorf("at least one owner is required")
 	}
+	return owners, nil
+}
 
-	// contract
-	contractNum := strings.ToLower(args[0])
-	propertyNum := strings.ToLower(args[1])
+// applyOwners makes owners[0] the primary owner of p and records every owner in Owners.
+// A single owner leaves Owners empty so solely owned properties keep their original shape.
+func applyOwners(p *property, owners []string) {
+	p.Owner = owners[0]
+	p.OwnerKey = strings.ToLower(owners[0])
+	p.Owners = nil
+	if len(owners) > 1 {
+		for _, owner := range owners {
+			p.Owners = append(p.Owners, strings.ToLower(owner))
+		}
+	}
+}
 
-	// ==== Create contract object and marshal to JSON ====
-	objectType := "contract"
-	contract := &contract{objectType, contractNum, propertyNum}
-	contractJSONasBytes, err := json.Marshal(contract)
+// checkNewProperty applies every rule initProperty enforces before writing: the argument
+// checks of validatePropertyArgs and that the property_num is not taken. validateProperty
+// runs the same function, so a dry run and a real create always agree. The error code to
+// report is returned alongside the error.
+func checkNewProperty(stub shim.ChaincodeStubInterface, args []string) (*property, string, error) {
+	newProperty, err := validatePropertyArgs(args)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errCodeInvalidArgs, err
 	}
 
-	// === Save object to state ===
-	err = stub.PutState(contractNum, contractJSONasBytes)
+	// ==== Check if property already exists ====
+	existingAsBytes, err := stub.GetState(newProperty.Property_num)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errCodeInternal, fmt.Errorf("failed to get property: %s", err)
+	} else if existingAsBytes != nil {
+		return nil, errCodeAlreadyExists, fmt.Errorf("this property already exists: %s", newProperty.Property_num)
 	}
-
-	// ==== Return success ====
-	fmt.Println("- end create contract")
-	return shim.Success(nil)
+	return newProperty, "", nil
 }
 
-// ===============================================
-// readValue - read a property, condition, contract from chaincode state
-// ===============================================
-func (t *SimpleChaincode) readValue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var key, jsonResp string
-	var err error
+// ============================================================
+// validateProperty - dry run of initProperty: checks the same arguments against the same
+// rules and returns {"valid":true} or {"valid":false,"errors":[...]}, writing nothing.
+// ============================================================
+func (t *SimpleChaincode) validateProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// propertyNum, propertyName, address, owner
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting number of the value to query")
+	result := map[string]interface{}{"valid": true}
+	_, errCode, err := checkNewProperty(stub, args)
+	if err != nil && errCode == errCodeInternal {
+		return errorJSON(errCode, err.Error())
+	} else if err != nil {
+		result = map[string]interface{}{"valid": false, "errors": []string{err.Error()}}
 	}
 
-	key = args[0]
-	valAsbytes, err := stub.GetState(key)
+	resultAsBytes, err := json.Marshal(result)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get value for " + key + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Value does not exist: " + key + "\"}"
-		return shim.Error(jsonResp)
+		return errorJSON(errCodeInternal, err.Error())
 	}
-
-	return shim.Success(valAsbytes)
+	return shim.Success(resultAsBytes)
 }
 
-// ===========================================================
-// transfer a property by setting a new owner name on the property
-// ===========================================================
-func (t *SimpleChaincode) transferProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// ============================================================
+// initPropertyBatch - create many properties in one transaction from a JSON array of
+// {"property_num","name","address","owner"} objects. Every entry is validated, and checked
+// against the rest of the batch and existing state, before anything is written, so an
+// invalid entry fails the whole batch.
+// ============================================================
+func (t *SimpleChaincode) initPropertyBatch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-		//   0       1
-		// "name", "bob"
-		if len(args) < 2 {
-			return shim.Error("Incorrect number of arguments. Expecting 2")
-		}
+	//   0
+	// "[{\"property_num\":\"1\",\"name\":\"...\",\"address\":\"...\",\"owner\":\"tom\"}, ...]"
 
-		propertyNum := args[0]
-		newOwner := strings.ToLower(args[1])
-		fmt.Println("- start transferProperty ", propertyNum, newOwner)
+	config, err := loadConfig(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = requireRole(stub, config.ListingRoles...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
 
-		propertyAsBytes, err := stub.GetState(propertyNum)
+	var entries []struct {
+		Property_num string `json:"property_num"`
+		Name         string `json:"name"`
+		Address      string `json:"address"`
+		Owner        string `json:"owner"`
+	}
+	err = json.Unmarshal([]byte(args[0]), &entries)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a JSON array of properties: " + err.Error())
+	}
+	fmt.Printf("- start initPropertyBatch: %d entries\n", len(entries))
+
+	createdAt, err := txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Validate everything before writing anything ====
+	properties := make([]*property, 0, len(entries))
+	seen := make(map[string]int)
+	for i, entry := range entries {
+		newProperty, err := validatePropertyArgs([]string{entry.Property_num, entry.Name, entry.Address, entry.Owner})
 		if err != nil {
-			return shim.Error("Failed to get property:" + err.Error())
-		} else if propertyAsBytes == nil {
-			return shim.Error("Property does not exist")
+			return errorJSON(errCodeInvalidArgs, fmt.Sprintf("property at index %d: %s", i, err))
+		}
+		if first, ok := seen[newProperty.Property_num]; ok {
+			return errorJSON(errCodeAlreadyExists, fmt.Sprintf("property at index %d: duplicates property_num %s at index %d", i, newProperty.Property_num, first))
 		}
+		seen[newProperty.Property_num] = i
+		newProperty.CreatedAt = createdAt
+		newProperty.UpdatedAt = createdAt
 
-		propertyToTransfer := property{}
-		err = json.Unmarshal(propertyAsBytes, &propertyToTransfer) //unmarshal it aka JSON.parse()
+		existingAsBytes, err := stub.GetState(newProperty.Property_num)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorJSON(errCodeInternal, "Failed to get property: " + err.Error())
+		} else if existingAsBytes != nil {
+			return errorJSON(errCodeAlreadyExists, fmt.Sprintf("property at index %d: %s already exists", i, newProperty.Property_num))
 		}
-		propertyToTransfer.Owner = newOwner //change the owner
+		properties = append(properties, newProperty)
+	}
 
-		propertyJSONasBytes, _ := json.Marshal(propertyToTransfer)
-		err = stub.PutState(propertyNum, propertyJSONasBytes) //rewrite the property
+	for _, newProperty := range properties {
+		propertyJSONasBytes, err := json.Marshal(newProperty)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		err = stub.PutState(newProperty.Property_num, propertyJSONasBytes)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		for _, ownerKey := range ownerKeysOf(*newProperty) {
+			err = putOwnerIndex(stub, ownerKey, newProperty.Property_num)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
 		}
+	}
 
-		fmt.Println("- end transferProperty (success)")
-		return shim.Success(nil)
+	fmt.Println("- end initPropertyBatch")
+	return shim.Success([]byte(fmt.Sprintf("{\"created\":%d}", len(properties))))
+}
+
+// ============================================================
+// initConditon
+// Returns the key the condition was stored under, i.e. the canonical condition_num.
+// Fires a "ConditionCreated" event carrying the stored JSON. Fabric keeps only the
+// last SetEvent of a transaction, so this must stay the only event it sets.
+// ============================================================
+func (t *SimpleChaincode) initConditon(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// conditionNum, propertyNum, seller, buyer, deposit, currency
+	return createCondition(stub, args, false)
+}
+
+// ============================================================
+// initConditionPrivate
+// Like initConditon, but the deposit is read from the transient map under "deposit" and
+// stored only in the depositCollection private data collection, so it never reaches the
+// world state, the transaction or the ConditionCreated event. The public condition carries
+// depositPrivate instead of a deposit and is left out of public deposit totals.
+// peer chaincode invoke ... -c '{"Args":["initConditionPrivate","1","100","tom","jerry","KRW"]}' --transient "{\"deposit\":\"$(echo -n 5000 | base64)\"}"
+// ============================================================
+func (t *SimpleChaincode) initConditionPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// conditionNum, propertyNum, seller, buyer, currency; transient: deposit
+
+	transientMap, err := stub.GetTransient()
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get transient data: " + err.Error())
+	}
+	depositAsBytes, ok := transientMap["deposit"]
+	if !ok {
+		return errorJSON(errCodeInvalidArgs, "deposit must be passed in the transient map")
+	}
+	return createCondition(stub, []string{args[0], args[1], args[2], args[3], string(depositAsBytes), args[4]}, true)
+}
+
+// createCondition validates and stores a condition for initConditon and initConditionPrivate.
+// With private set the deposit goes to depositCollection instead of the public record.
+func createCondition(stub shim.ChaincodeStubInterface, args []string, private bool) pb.Response {
+	var err error
+
+	// ==== Input sanitation ====
+	fmt.Println("- start init condition")
+	if len(args[0]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a non-empty string")
+	}
+	if len(args[2]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "3rd argument must be a non-empty string")
+	}
+	if len(args[3]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "4th argument must be a non-empty string")
+	}
+	if len(args[4]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "5th argument must be a non-empty string")
+	}
+	if len(args[5]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "6th argument must be a non-empty string")
+	}
+
+	// condition
+	conditionNo, err := parsePositiveInt("condition_num", args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	propertyNo, err := parsePositiveInt("property_num", args[1])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	conditionNum := strconv.Itoa(conditionNo)
+	propertyNum := strconv.Itoa(propertyNo)
+	seller := strings.ToLower(strings.TrimSpace(args[2]))
+	buyer := strings.ToLower(strings.TrimSpace(args[3]))
+	if err = checkLength("seller", seller, maxPartyLength); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	if err = checkLength("buyer", buyer, maxPartyLength); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	if seller == buyer {
+		return errorJSON(errCodeInvalidArgs, "seller and buyer must be different parties")
+	}
+	deposit, err := parsePositiveInt("deposit", args[4])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	if err = checkMaxDeposit(stub, deposit); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	currency := strings.ToUpper(strings.TrimSpace(args[5]))
+	if !isValidCurrency(currency) {
+		return errorJSON(errCodeInvalidArgs, "Unknown currency " + currency + ". Expecting one of: " + strings.Join(validCurrencies, ", "))
+	}
+
+	// ==== Check if the key is taken; properties, conditions and contracts share one key space ====
+	existingAsBytes, err := stub.GetState(conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get condition: " + err.Error())
+	} else if existingAsBytes != nil {
+		return errorJSON(errCodeAlreadyExists, "this key already exists: " + conditionNum)
+	}
+
+	// ==== Check the referenced property exists ====
+	propertyAsBytes, err := stub.GetState(propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get property: " + err.Error())
+	}
+	referencedProperty := property{}
+	if propertyAsBytes == nil || json.Unmarshal(propertyAsBytes, &referencedProperty) != nil || referencedProperty.ObjectType != "property" {
+		return errorJSON(errCodeReferenceMissing, "referenced property " + propertyNum + " does not exist")
+	}
+	if !isOwnerOf(referencedProperty, seller) {
+		return errorJSON(errCodeInvalidArgs, "seller " + seller + " is not an owner of property " + propertyNum + " (owned by " + strings.Join(ownerKeysOf(referencedProperty), ", ") + ")")
+	}
+
+	// ==== Competing offers are fine until one becomes a contract; then the listing is taken ====
+	// completed deals do not block, so the new owner can list the property again
+	activeContractNum, err := findActiveContractForProperty(stub, propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if activeContractNum != "" {
+		return errorJSON(errCodeInvalidState, "Property " + propertyNum + " is under active contract " + activeContractNum)
+	}
+
+	createdAt, err := txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Create condition object and marshal to JSON ====
+	objectType := "condition"
+	publicDeposit := deposit
+	if private {
+		publicDeposit = 0
+	}
+	condition := &conditionOfContract{objectType, conditionNum, propertyNum, seller, buyer, publicDeposit, currency, createdAt, createdAt, private, false}
+	conditionJSONasBytes, err := json.Marshal(condition)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// === Save the deposit to the private data collection ===
+	if private {
+		depositJSONasBytes, err := json.Marshal(&conditionDeposit{"conditionDeposit", conditionNum, deposit})
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		err = stub.PutPrivateData(depositCollection, conditionNum, depositJSONasBytes)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+	}
+
+	// === Save object to state ===
+	err = stub.PutState(conditionNum, conditionJSONasBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Index the condition by property, so deleteProperty sees the reference ====
+	err = putIndexEntry(stub, "property~condition", propertyNum, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Emit ConditionCreated with the stored object as payload ====
+	err = setEvent(stub, "ConditionCreated", conditionNum, "condition", conditionJSONasBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Return success ====
+	fmt.Println("- end init contract condition")
+	return shim.Success([]byte(conditionNum))
+}
+
+// ============================================================
+// CreateContract
+// Returns the key the contract was stored under, i.e. the canonical contract_num.
+// Fires a "ContractCreated" event carrying the stored JSON. Fabric keeps only the
+// last SetEvent of a transaction, so this must stay the only event it sets.
+// ============================================================
+func (t *SimpleChaincode) CreateContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var err error
+
+	// contractNum, conditionNum
+
+	// ==== Input sanitation ====
+	fmt.Println("- start create contract")
+	if len(args[0]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty string")
+	}
+	if len(args[1]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a non-empty string")
+	}
+
+	// contract
+	contractNo, err := parsePositiveInt("contract_num", args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	conditionNo, err := parsePositiveInt("condition_num", args[1])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	contractNum := strconv.Itoa(contractNo)
+	conditionNum := strconv.Itoa(conditionNo)
+
+	// ==== Check if the key is taken; properties, conditions and contracts share one key space ====
+	takenAsBytes, err := stub.GetState(contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get contract: " + err.Error())
+	} else if takenAsBytes != nil {
+		return errorJSON(errCodeAlreadyExists, "this key already exists: " + contractNum)
+	}
+
+	// ==== Check the referenced condition exists and has no contract yet ====
+	conditionAsBytes, err := stub.GetState(conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get condition: " + err.Error())
+	}
+	referencedCondition := conditionOfContract{}
+	if conditionAsBytes == nil || json.Unmarshal(conditionAsBytes, &referencedCondition) != nil || referencedCondition.ObjectType != "condition" {
+		return errorJSON(errCodeReferenceMissing, "referenced condition " + conditionNum + " does not exist")
+	}
+	existingAsBytes, err := findContractForCondition(stub, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if existingAsBytes != nil {
+		existing := contract{}
+		json.Unmarshal(existingAsBytes, &existing)
+		return errorJSON(errCodeAlreadyExists, "condition " + conditionNum + " already has contract " + existing.Contract_num)
+	}
+
+	// ==== One active deal per property: a competing offer cannot become a second contract ====
+	activeContractNum, err := findActiveContractForProperty(stub, referencedCondition.Property_num)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if activeContractNum != "" {
+		return errorJSON(errCodeInvalidState, "Property " + referencedCondition.Property_num + " is already in active contract " + activeContractNum)
+	}
+
+	createdAt, err := txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Create contract object and marshal to JSON ====
+	objectType := "contract"
+	contract := &contract{objectType, contractNum, conditionNum, "pending", createdAt, createdAt, nil, nil, nil}
+	contractJSONasBytes, err := json.Marshal(contract)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// === Save object to state ===
+	err = stub.PutState(contractNum, contractJSONasBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Index the contract by condition, enforcing one contract per condition ====
+	err = putIndexEntry(stub, "condition~contract", conditionNum, contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	// ==== and as the property's active deal until it is cancelled or completed ====
+	err = putIndexEntry(stub, "property~activecontract", referencedCondition.Property_num, contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Emit ContractCreated with the stored object as payload ====
+	err = setEvent(stub, "ContractCreated", contractNum, "contract", contractJSONasBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Return success ====
+	fmt.Println("- end create contract")
+	return shim.Success([]byte(contractNum))
+}
+
+// ============================================================
+// createPropertyAuto, createConditionAuto and createContractAuto are initProperty,
+// initConditon and CreateContract without the leading number argument: the number is
+// derived from the transaction ID, which every endorser sees the same, and returned.
+// ============================================================
+func (t *SimpleChaincode) createPropertyAuto(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// propertyName, address, owner
+	propertyNum, err := txNumericID(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return t.initProperty(stub, append([]string{propertyNum}, args...))
+}
+
+func (t *SimpleChaincode) createConditionAuto(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// propertyNum, seller, buyer, deposit, currency
+	conditionNum, err := txNumericID(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return t.initConditon(stub, append([]string{conditionNum}, args...))
+}
+
+func (t *SimpleChaincode) createContractAuto(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// conditionNum
+	contractNum, err := txNumericID(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return t.CreateContract(stub, append([]string{contractNum}, args...))
+}
+
+// txNumericID turns the first 15 hex digits (60 bits) of the transaction ID into a positive
+// decimal number, so generated keys match the canonical numeric keys callers supply. Distinct
+// transactions give distinct numbers barring a 60-bit hash collision.
+func txNumericID(stub shim.ChaincodeStubInterface) (string, error) {
+	txID := stub.GetTxID()
+	if len(txID) < 15 {
+		return "", fmt.Errorf("transaction ID %s is too short to derive a key from", txID)
+	}
+	n, err := strconv.ParseUint(txID[:15], 16, 64)
+	if err != nil {
+		return "", fmt.Errorf("transaction ID %s is not hex: %s", txID, err)
+	}
+	if n == 0 {
+		n = 1 //keys must be positive numbers
+	}
+	return strconv.FormatUint(n, 10), nil
+}
+
+// ===============================================
+// readValue - read a property, condition, contract from chaincode state
+// An optional 2nd argument "pretty" indents the JSON for reading on the CLI.
+// ===============================================
+func (t *SimpleChaincode) readValue(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var key string
+	var err error
+
+	//   0    1 (optional)
+	// "1", "pretty"
+	key = args[0]
+	pretty := len(args) > 1 && args[1] == "pretty"
+	if len(args) > 1 && !pretty {
+		return errorJSON(errCodeInvalidArgs, "Unknown option " + args[1] + ". Expecting pretty")
+	}
+
+	valAsbytes, err := stub.GetState(key)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get value for " + key)
+	} else if valAsbytes == nil {
+		return errorJSON(errCodeNotFound, "Value does not exist: " + key)
+	}
+
+	if pretty {
+		var buffer bytes.Buffer
+		err = json.Indent(&buffer, valAsbytes, "", "  ")
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to decode JSON of: " + key)
+		}
+		return shim.Success(buffer.Bytes())
+	}
+	return shim.Success(valAsbytes)
+}
+
+// ===============================================
+// readValues - read several keys in one call, given as separate arguments or as one
+// JSON array. Returns an object mapping each key to its value, or null if it is missing.
+// ===============================================
+func (t *SimpleChaincode) readValues(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0    1    2
+	// "1", "2", "100"   or   "[\"1\",\"2\",\"100\"]"
+	keys := args
+	if len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "[") {
+		err := json.Unmarshal([]byte(args[0]), &keys)
+		if err != nil {
+			return errorJSON(errCodeInvalidArgs, "1st argument must be a JSON array of keys: " + err.Error())
+		}
+	}
+
+	values := make(map[string]json.RawMessage, len(keys))
+	for _, key := range keys {
+		valAsbytes, err := stub.GetState(key)
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to get value for " + key)
+		}
+		if valAsbytes == nil {
+			values[key] = json.RawMessage("null")
+		} else if json.Valid(valAsbytes) {
+			values[key] = json.RawMessage(valAsbytes)
+		} else {
+			values[key], _ = json.Marshal(string(valAsbytes)) //not a JSON record, return it as a string
+		}
+	}
+
+	valuesAsBytes, err := json.Marshal(values)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(valuesAsBytes)
+}
+
+// ===========================================================
+// transfer a property by setting a new owner name on the property
+// Only a current owner (matched by enrollment ID) or an admin may transfer. An admin makes
+// the new owner the sole owner; a co-owner only transfers their own share and the other
+// co-owners stay on the record.
+// An optional 4th argument is the version the caller last read, failing with CONFLICT
+// if the property has been written since; pass "" as transferId to use it alone.
+// ===========================================================
+func (t *SimpleChaincode) transferProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+		//   0       1       2 (optional transferId)   3 (optional expected version)
+		// "name", "bob", "tx-42",                  "3"
+
+		propertyNum := args[0]
+		newOwner := strings.TrimSpace(args[1])
+		newOwnerKey := strings.ToLower(newOwner)
+		if len(newOwner) <= 0 {
+			return errorJSON(errCodeInvalidArgs, "2nd argument must be a non-empty string")
+		}
+		if err := checkLength("owner", newOwner, maxPartyLength); err != nil {
+			return errorJSON(errCodeInvalidArgs, err.Error())
+		}
+		fmt.Println("- start transferProperty ", propertyNum, newOwner)
+
+		// ==== A replayed transferId returns the prior result instead of transferring again ====
+		// The original invoker no longer owns the property after the transfer, so a replay is
+		// authorized against the invoker recorded with the transferId instead of the owners.
+		invoker, err := invokerID(stub)
+		if err != nil {
+			return errorJSON(errCodeUnauthorized, err.Error())
+		}
+		var idemKey string
+		if len(args) > 2 && len(args[2]) > 0 {
+			idemKey, err = stub.CreateCompositeKey("transferidem~id", []string{args[2]})
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			processedAsBytes, err := stub.GetState(idemKey)
+			if err != nil {
+				return errorJSON(errCodeInternal, "Failed to get transferId:" + err.Error())
+			} else if processedAsBytes != nil {
+				processed := processedTransfer{}
+				err = json.Unmarshal(processedAsBytes, &processed)
+				if err != nil {
+					return errorJSON(errCodeInternal, err.Error())
+				}
+				admin, err := isAdmin(stub)
+				if err != nil {
+					return errorJSON(errCodeUnauthorized, err.Error())
+				}
+				if processed.Invoker != invoker && !admin {
+					return errorJSON(errCodeUnauthorized, "transferId " + args[2] + " was already used by another invoker")
+				}
+				if processed.Property_num != propertyNum || processed.NewOwner != newOwnerKey {
+					return errorJSON(errCodeInvalidArgs, "transferId " + args[2] + " was already used for a different transfer")
+				}
+				fmt.Println("- end transferProperty (replayed transferId " + args[2] + ")")
+				return shim.Success(nil)
+			}
+		}
+
+		propertyAsBytes, err := stub.GetState(propertyNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to get property:" + err.Error())
+		} else if propertyAsBytes == nil {
+			return errorJSON(errCodeNotFound, "Property does not exist")
+		}
+
+		propertyToTransfer := property{}
+		err = json.Unmarshal(propertyAsBytes, &propertyToTransfer) //unmarshal it aka JSON.parse()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		err = requireOwnerOrAdmin(stub, propertyToTransfer.OwnerMSP, ownerKeysOf(propertyToTransfer)...)
+		if err != nil {
+			return errorJSON(errCodeUnauthorized, err.Error())
+		}
+		if len(args) > 3 {
+			err = checkExpectedVersion(propertyToTransfer, args[3])
+			if err != nil {
+				return errorJSON(errCodeConflict, err.Error())
+			}
+		}
+		owners, err := transferredOwners(stub, propertyToTransfer, newOwner)
+		if err != nil {
+			return errorJSON(errCodeUnauthorized, err.Error())
+		}
+		if sameOwners(propertyToTransfer, owners) {
+			return errorJSON(errCodeInvalidArgs, "property already owned by " + propertyToTransfer.Owner)
+		}
+
+		// ==== A property tied up in a pending or signed contract cannot move ====
+		activeContractNum, err := findActiveContractForProperty(stub, propertyNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		} else if activeContractNum != "" {
+			return errorJSON(errCodeInvalidState, "Property " + propertyNum + " is tied up in active contract " + activeContractNum)
+		}
+
+		err = setPropertyOwners(stub, &propertyToTransfer, owners)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+
+		if idemKey != "" {
+			processedAsBytes, _ := json.Marshal(processedTransfer{propertyNum, newOwnerKey, invoker})
+			err = stub.PutState(idemKey, processedAsBytes) //remember the transferId
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+		}
+
+		fmt.Println("- end transferProperty (success)")
+		return shim.Success(nil)
+}
+
+// ===========================================================
+// sellProperty - transfer a property like transferProperty and append the sale price to its
+// saleHistory, giving a price history on the record itself. As with transferProperty, a
+// co-owner only sells their own share.
+// ===========================================================
+func (t *SimpleChaincode) sellProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1        2
+	// "100", "bob", "350000"
+	propertyNum := args[0]
+	newOwner := strings.TrimSpace(args[1])
+	if len(newOwner) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a non-empty string")
+	}
+	if err := checkLength("owner", newOwner, maxPartyLength); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	price, err := parsePositiveInt("price", args[2])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	fmt.Println("- start sellProperty ", propertyNum, newOwner, price)
+
+	propertyToSell := property{}
+	err = getRecord(stub, propertyNum, "property", &propertyToSell)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	err = requireOwnerOrAdmin(stub, propertyToSell.OwnerMSP, ownerKeysOf(propertyToSell)...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	owners, err := transferredOwners(stub, propertyToSell, newOwner)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if sameOwners(propertyToSell, owners) {
+		return errorJSON(errCodeInvalidArgs, "property already owned by " + propertyToSell.Owner)
+	}
+
+	// ==== A property tied up in a pending or signed contract cannot move ====
+	activeContractNum, err := findActiveContractForProperty(stub, propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if activeContractNum != "" {
+		return errorJSON(errCodeInvalidState, "Property " + propertyNum + " is tied up in active contract " + activeContractNum)
+	}
+
+	soldAt, err := txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	propertyToSell.SaleHistory = append(propertyToSell.SaleHistory, saleRecord{strings.ToLower(newOwner), price, soldAt})
+
+	err = setPropertyOwners(stub, &propertyToSell, owners)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end sellProperty (success)")
+	return shim.Success(nil)
+}
+
+// replaceOwner returns the owners of p with ownerKey's share passed to newOwner. The other
+// co-owners stay, in order; a solely owned property simply goes to newOwner.
+func replaceOwner(p property, ownerKey string, newOwner string) []string {
+	if len(p.Owners) == 0 {
+		return []string{newOwner}
+	}
+	var owners []string
+	seen := make(map[string]bool)
+	for i, key := range p.Owners {
+		if key == ownerKey {
+			key = newOwner
+		} else if i == 0 {
+			key = p.Owner //keep the display form of a remaining primary owner
+		}
+		if !seen[strings.ToLower(key)] {
+			seen[strings.ToLower(key)] = true
+			owners = append(owners, key)
+		}
+	}
+	return owners
+}
+
+// transferredOwners returns the owners of p once the invoker hands it to newOwner. An admin
+// moves the whole property; a co-owner only moves their own share, since one co-owner cannot
+// sign away the others'. The caller has already checked the invoker owns p or is an admin.
+func transferredOwners(stub shim.ChaincodeStubInterface, p property, newOwner string) ([]string, error) {
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return nil, err
+	} else if admin {
+		return []string{newOwner}, nil
+	}
+	invoker, err := invokerName(stub)
+	if err != nil {
+		return nil, err
+	}
+	return replaceOwner(p, invoker, newOwner), nil
+}
+
+// sameOwners reports whether owners leaves the owner keys of p unchanged.
+func sameOwners(p property, owners []string) bool {
+	ownerKeys := ownerKeysOf(p)
+	if len(owners) != len(ownerKeys) {
+		return false
+	}
+	for i, owner := range owners {
+		if strings.ToLower(owner) != ownerKeys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setPropertyOwners replaces the owners of p, owners[0] becoming the primary owner, rewrites
+// it and moves its "owner~propertynum" index entries from the old owners to the new ones.
+func setPropertyOwners(stub shim.ChaincodeStubInterface, p *property, owners []string) error {
+	updatedAt, err := txTimestamp(stub)
+	if err != nil {
+		return err
+	}
+
+	oldOwnerKeys := ownerKeysOf(*p)
+	applyOwners(p, owners) //change the owners
+	p.UpdatedAt = updatedAt
+	p.Version++
+
+	propertyJSONasBytes, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(p.Property_num, propertyJSONasBytes) //rewrite the property
+	if err != nil {
+		return err
+	}
+
+	// delete before put, so an owner who keeps a share keeps the entry
+	for _, ownerKey := range oldOwnerKeys {
+		err = delOwnerIndex(stub, ownerKey, p.Property_num)
+		if err != nil {
+			return err
+		}
+	}
+	for _, ownerKey := range ownerKeysOf(*p) {
+		err = putOwnerIndex(stub, ownerKey, p.Property_num)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ===========================================================
+// addOwner - add a co-owner to a property. Only a current owner or an admin may add one,
+// and not while the property is tied up in an active contract.
+// ===========================================================
+func (t *SimpleChaincode) addOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "100", "lee"
+	propertyNum := args[0]
+	newOwner := strings.TrimSpace(args[1])
+	if len(newOwner) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a non-empty string")
+	}
+	if err := checkLength("owner", newOwner, maxPartyLength); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	fmt.Println("- start addOwner ", propertyNum, newOwner)
+
+	propertyToChange := property{}
+	err := getRecord(stub, propertyNum, "property", &propertyToChange)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	err = requireOwnerOrAdmin(stub, propertyToChange.OwnerMSP, ownerKeysOf(propertyToChange)...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if isOwnerOf(propertyToChange, strings.ToLower(newOwner)) {
+		return errorJSON(errCodeAlreadyExists, newOwner + " already owns property " + propertyNum)
+	}
+
+	activeContractNum, err := findActiveContractForProperty(stub, propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if activeContractNum != "" {
+		return errorJSON(errCodeInvalidState, "Property " + propertyNum + " is tied up in active contract " + activeContractNum)
+	}
+
+	owners := append([]string{propertyToChange.Owner}, ownerKeysOf(propertyToChange)[1:]...)
+	err = setPropertyOwners(stub, &propertyToChange, append(owners, newOwner))
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end addOwner (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// removeOwner - remove a co-owner from a property. Co-owners may only remove themselves;
+// an admin may remove anyone. The last owner cannot be removed; use transferProperty to
+// hand the property over instead. Removing the primary owner promotes the next co-owner.
+// ===========================================================
+func (t *SimpleChaincode) removeOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "100", "lee"
+	propertyNum := args[0]
+	ownerKey := strings.ToLower(strings.TrimSpace(args[1]))
+	fmt.Println("- start removeOwner ", propertyNum, ownerKey)
+
+	propertyToChange := property{}
+	err := getRecord(stub, propertyNum, "property", &propertyToChange)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	err = requireOwnerOrAdmin(stub, propertyToChange.OwnerMSP, ownerKey)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if !isOwnerOf(propertyToChange, ownerKey) {
+		return errorJSON(errCodeNotFound, ownerKey + " does not own property " + propertyNum)
+	}
+	if len(propertyToChange.Owners) <= 1 {
+		return errorJSON(errCodeInvalidState, "cannot remove the last owner of property " + propertyNum)
+	}
+
+	activeContractNum, err := findActiveContractForProperty(stub, propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if activeContractNum != "" {
+		return errorJSON(errCodeInvalidState, "Property " + propertyNum + " is tied up in active contract " + activeContractNum)
+	}
+
+	var owners []string
+	for i, key := range propertyToChange.Owners {
+		if key == ownerKey {
+			continue
+		}
+		if i == 0 {
+			key = propertyToChange.Owner //keep the display form of a remaining primary owner
+		}
+		owners = append(owners, key)
+	}
+	err = setPropertyOwners(stub, &propertyToChange, owners)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end removeOwner (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// updateProperty - merge a JSON object of changed fields, e.g. {"name":"villa"}, onto an
+// existing property. Only name and address can change here; owner, owner_key and owners are
+// ignored (use transferProperty or addOwner/removeOwner) and property_num is rejected.
+// An optional 3rd argument is the version the caller last read; if the property has been
+// written since, the update fails with CONFLICT and the caller should re-read and retry.
+// Fires a "PropertyUpdated" event carrying the stored JSON.
+// ===========================================================
+func (t *SimpleChaincode) updateProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                1                                 2 (optional expected version)
+	// "100", "{\"address\":\"seoul jongno 1\"}", "3"
+	propertyNum := args[0]
+	fmt.Println("- start updateProperty ", propertyNum)
+
+	var changes map[string]json.RawMessage
+	err := json.Unmarshal([]byte(args[1]), &changes)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a JSON object: " + err.Error())
+	}
+
+	propertyToUpdate := property{}
+	err = getRecord(stub, propertyNum, "property", &propertyToUpdate)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	err = requireOwnerOrAdmin(stub, propertyToUpdate.OwnerMSP, ownerKeysOf(propertyToUpdate)...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if len(args) > 2 {
+		err = checkExpectedVersion(propertyToUpdate, args[2])
+		if err != nil {
+			return errorJSON(errCodeConflict, err.Error())
+		}
+	}
+
+	for field, raw := range changes {
+		var value string
+		switch field {
+		case "owner", "owner_key", "owners":
+			continue //ownership only changes through transferProperty
+		case "property_num":
+			return errorJSON(errCodeInvalidArgs, "property_num cannot be changed")
+		case "version":
+			return errorJSON(errCodeInvalidArgs, "version cannot be changed; pass the expected version as the 3rd argument")
+		case "name", "address":
+			if json.Unmarshal(raw, &value) != nil {
+				return errorJSON(errCodeInvalidArgs, field + " must be a string")
+			}
+		default:
+			return errorJSON(errCodeInvalidArgs, "Unknown field " + field + ". Expecting name or address")
+		}
+
+		// normalize the same way initProperty does
+		if field == "name" {
+			if len(value) <= 0 {
+				return errorJSON(errCodeInvalidArgs, "name must be a non-empty string")
+			}
+			if err = checkLength("name", value, maxNameLength); err != nil {
+				return errorJSON(errCodeInvalidArgs, err.Error())
+			}
+			propertyToUpdate.Name = strings.ToLower(value)
+		} else {
+			address := strings.ToLower(strings.Join(strings.Fields(value), " "))
+			if len(address) <= 0 {
+				return errorJSON(errCodeInvalidArgs, "address must not be blank")
+			}
+			if err = checkLength("address", address, maxAddressLength); err != nil {
+				return errorJSON(errCodeInvalidArgs, err.Error())
+			}
+			propertyToUpdate.Address = address
+		}
+	}
+
+	propertyToUpdate.OwnerKey = ownerKeyOf(propertyToUpdate)
+	propertyToUpdate.Version++
+	propertyToUpdate.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	propertyJSONasBytes, err := json.Marshal(propertyToUpdate)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.PutState(propertyNum, propertyJSONasBytes) //rewrite the property
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Emit PropertyUpdated with the stored object as payload ====
+	err = setEvent(stub, "PropertyUpdated", propertyNum, "property", propertyJSONasBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end updateProperty (success)")
+	return shim.Success(nil)
+}
+
+// ==================================================================================
+// transferPropertiesBasedOnOwner will transfer all properties of a given owner,
+// e.g. for an estate transfer or when one company acquires another's portfolio.
+// On co-owned properties only that owner's share moves; the other co-owners stay.
+// Only that owner or an admin may move the portfolio.
+// Uses the "owner~propertynum" composite key index, so it also works on LevelDB.
+// ==================================================================================
+func (t *SimpleChaincode) transferPropertiesBasedOnOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "tom", "jerry"
+
+	ownerKey := strings.ToLower(strings.TrimSpace(args[0]))
+	newOwner := strings.TrimSpace(args[1])
+	if len(ownerKey) <= 0 || len(newOwner) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "owner names must be non-empty strings")
+	}
+	if err := checkLength("owner", newOwner, maxPartyLength); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	fmt.Println("- start transferPropertiesBasedOnOwner ", ownerKey, newOwner)
+
+	err := requireOwnerOrAdmin(stub, "", ownerKey)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	// Query the owner~propertynum index by owner
+	// This will execute a key range query on all keys starting with 'owner'
+	ownerPropertyResultsIterator, err := stub.GetStateByPartialCompositeKey("owner~propertynum", []string{ownerKey})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer ownerPropertyResultsIterator.Close()
+
+	var propertyNums []string
+	for ownerPropertyResultsIterator.HasNext() {
+		responseRange, err := ownerPropertyResultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+
+		// get the owner and property number from the composite key
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		propertyNums = append(propertyNums, compositeKeyParts[1])
+	}
+
+	// Iterate through the properties of the owner
+	transferred := 0
+	for _, propertyNum := range propertyNums {
+		propertyToTransfer := property{}
+		err = getRecord(stub, propertyNum, "property", &propertyToTransfer)
+		if err != nil {
+			continue // dangling index entry
+		}
+		err = requireOwnerOrAdmin(stub, propertyToTransfer.OwnerMSP, ownerKey)
+		if err != nil {
+			return errorJSON(errCodeUnauthorized, "Property " + propertyNum + ": " + err.Error())
+		}
+		activeContractNum, err := findActiveContractForProperty(stub, propertyNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		} else if activeContractNum != "" {
+			return errorJSON(errCodeInvalidState, "Property " + propertyNum + " is tied up in active contract " + activeContractNum)
+		}
+		// a co-owned property only passes on this owner's share
+		err = setPropertyOwners(stub, &propertyToTransfer, replaceOwner(propertyToTransfer, ownerKey, newOwner))
+		if err != nil {
+			return errorJSON(errCodeInternal, "Transfer failed for " + propertyNum + ": " + err.Error())
+		}
+		transferred++
+	}
+
+	fmt.Printf("- end transferPropertiesBasedOnOwner: %d transferred\n", transferred)
+	return shim.Success([]byte(fmt.Sprintf("{\"transferred\":%d}", transferred)))
+}
+
+// ===========================================================================================
+// repairContractRecords - migration for ledgers written by the broken CreateContract, which
+// marshaled contracts with the conditionOfContract struct. Such records have no contract_num
+// and either carry docType "contract" or masquerade as a condition stored under a key that
+// is not their own condition_num. Records whose condition_num still resolves to a condition
+// are rewritten as proper contracts; everything else is reported as unrepairable.
+// A valid status and the timestamps carry over, and the condition-shaped fields (seller,
+// buyer, deposit, ...) are kept under legacy so nothing stored is lost.
+// With the optional dryRun argument set to true it only reports, without writing.
+// Each invocation scans at most pageSize (default migrateBatchSize) keys, starting at the
+// optional startKey, and reports the key to continue from as nextKey, empty once done.
+// ===========================================================================================
+func (t *SimpleChaincode) repairContractRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0 (optional dryRun)   1 (optional startKey)   2 (optional pageSize)
+	// "true",                 "1200",                 "100"
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	dryRun := false
+	if len(args) > 0 {
+		dryRun, err = strconv.ParseBool(args[0])
+		if err != nil {
+			return errorJSON(errCodeInvalidArgs, "1st argument must be true or false")
+		}
+	}
+	startKey := ""
+	if len(args) > 1 {
+		startKey = args[1]
+	}
+	pageSize := migrateBatchSize
+	if len(args) > 2 {
+		pageSize, err = parsePositiveInt("pageSize", args[2])
+		if err != nil {
+			return errorJSON(errCodeInvalidArgs, err.Error())
+		}
+	}
+	fmt.Println("- start repairContractRecords ", dryRun, startKey)
+
+	resultsIterator, err := stub.GetStateByRange(startKey, "")
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	report := repairReport{Repaired: []string{}, Unrepairable: []string{}, DryRun: dryRun}
+	scanned := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		if scanned == pageSize {
+			report.NextKey = queryResponse.Key
+			break
+		}
+		scanned++
+
+		var record map[string]interface{}
+		if err = json.Unmarshal(queryResponse.Value, &record); err != nil {
+			continue // not one of our JSON records
+		}
+		if _, ok := record["contract_num"]; ok {
+			continue // already a well-formed contract
+		}
+		docType, _ := record["docType"].(string)
+		conditionNum, _ := record["condition_num"].(string)
+		if docType != "contract" && !(docType == "condition" && conditionNum != queryResponse.Key) {
+			continue
+		}
+
+		if conditionNum == "" {
+			report.Unrepairable = append(report.Unrepairable, queryResponse.Key)
+			continue
+		}
+		conditionAsBytes, err := stub.GetState(conditionNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to get condition:" + err.Error())
+		}
+		linkedCondition := conditionOfContract{}
+		if conditionAsBytes == nil || json.Unmarshal(conditionAsBytes, &linkedCondition) != nil || linkedCondition.ObjectType != "condition" {
+			report.Unrepairable = append(report.Unrepairable, queryResponse.Key)
+			continue
+		}
+
+		if dryRun {
+			report.Repaired = append(report.Repaired, queryResponse.Key)
+			continue
+		}
+
+		repairedContract := &contract{ObjectType: "contract", Contract_num: queryResponse.Key, Condition_num: conditionNum, Status: "pending"}
+		status, _ := record["status"].(string)
+		for _, validStatus := range validContractStatuses {
+			if status == validStatus {
+				repairedContract.Status = status
+			}
+		}
+		repairedContract.CreatedAt, _ = record["createdAt"].(string)
+		repairedContract.UpdatedAt, _ = record["updatedAt"].(string)
+		for field, value := range record {
+			switch field {
+			case "docType", "condition_num", "status", "createdAt", "updatedAt":
+			default:
+				if repairedContract.Legacy == nil {
+					repairedContract.Legacy = make(map[string]interface{})
+				}
+				repairedContract.Legacy[field] = value
+			}
+		}
+		contractJSONasBytes, err := json.Marshal(repairedContract)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		err = stub.PutState(queryResponse.Key, contractJSONasBytes)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		err = putIndexEntry(stub, "condition~contract", conditionNum, queryResponse.Key)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		if repairedContract.Status == "pending" || repairedContract.Status == "signed" {
+			err = putIndexEntry(stub, "property~activecontract", linkedCondition.Property_num, queryResponse.Key)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+		}
+		report.Repaired = append(report.Repaired, queryResponse.Key)
+	}
+
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Printf("- end repairContractRecords: %d repaired, %d unrepairable\n", len(report.Repaired), len(report.Unrepairable))
+	return shim.Success(reportAsBytes)
+}
+
+// ===========================================================================================
+// migrateRecords - admin upgrade step that backfills fields added after records of a docType
+// were first written: owner_key on properties, status "pending" on contracts without one,
+// and createdAt/updatedAt on every type. A missing createdAt is set to the migration's
+// transaction time, since the real creation time is unknown. Conditions also get their
+// "property~condition" index entry, and contracts their "condition~contract" and, while
+// pending or signed, "property~activecontract" entries, which records written before the
+// indexes lack.
+// Each invocation scans at most migrateBatchSize records of the docType, starting at the
+// optional startKey, and returns {"migrated":n,"nextKey":"..."}. Invoke again with nextKey
+// until it comes back empty. Pagination APIs are read-only in Fabric, so batches follow keys.
+// ===========================================================================================
+func (t *SimpleChaincode) migrateRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0           1 (optional startKey)
+	// "contract", "1200"
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	docType := strings.ToLower(args[0])
+	if err := checkDocType(stub, docType); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	startKey := ""
+	if len(args) > 1 {
+		startKey = args[1]
+	}
+	fmt.Println("- start migrateRecords ", docType, startKey)
+
+	migratedAt, err := txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(startKey, "")
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	migrated, scanned := 0, 0
+	nextKey := ""
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		var header struct {
+			ObjectType string `json:"docType"`
+		}
+		if json.Unmarshal(queryResponse.Value, &header) != nil || header.ObjectType != docType {
+			continue
+		}
+		if scanned == migrateBatchSize {
+			nextKey = queryResponse.Key
+			break
+		}
+		scanned++
+
+		// unmarshal into the current struct, so fields the record predates start out zero
+		var record interface{}
+		var changed bool
+		switch docType {
+		case "property":
+			p := &property{}
+			json.Unmarshal(queryResponse.Value, p)
+			if p.OwnerKey == "" {
+				p.OwnerKey = ownerKeyOf(*p)
+				changed = true
+			}
+			changed = backfillTimestamps(&p.CreatedAt, &p.UpdatedAt, migratedAt) || changed
+			if changed {
+				p.Version++
+			}
+			record = p
+		case "condition":
+			c := &conditionOfContract{}
+			json.Unmarshal(queryResponse.Value, c)
+			changed = backfillTimestamps(&c.CreatedAt, &c.UpdatedAt, migratedAt)
+			record = c
+			err = putIndexEntry(stub, "property~condition", c.Property_num, queryResponse.Key)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+		case "contract":
+			c := &contract{}
+			json.Unmarshal(queryResponse.Value, c)
+			if c.Status == "" {
+				c.Status = "pending"
+				changed = true
+			}
+			changed = backfillTimestamps(&c.CreatedAt, &c.UpdatedAt, migratedAt) || changed
+			record = c
+			err = putIndexEntry(stub, "condition~contract", c.Condition_num, queryResponse.Key)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			linkedCondition := conditionOfContract{}
+			if (c.Status == "pending" || c.Status == "signed") && getRecord(stub, c.Condition_num, "condition", &linkedCondition) == nil {
+				err = putIndexEntry(stub, "property~activecontract", linkedCondition.Property_num, queryResponse.Key)
+				if err != nil {
+					return errorJSON(errCodeInternal, err.Error())
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		recordAsBytes, err := json.Marshal(record)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		err = stub.PutState(queryResponse.Key, recordAsBytes)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		migrated++
+	}
+
+	fmt.Printf("- end migrateRecords: %d migrated\n", migrated)
+	return shim.Success([]byte(fmt.Sprintf("{\"migrated\":%d,\"nextKey\":\"%s\"}", migrated, nextKey)))
+}
+
+// backfillTimestamps fills an empty createdAt with migratedAt and an empty updatedAt with
+// createdAt, reporting whether either was changed.
+func backfillTimestamps(createdAt *string, updatedAt *string, migratedAt string) bool {
+	changed := false
+	if *createdAt == "" {
+		*createdAt = migratedAt
+		changed = true
+	}
+	if *updatedAt == "" {
+		*updatedAt = *createdAt
+		changed = true
+	}
+	return changed
+}
+
+// ===========================================================================================
+// getAggregateDepositByProperty - sums the deposits of the open conditions on a property,
+// showing the total committed interest in a listing. Conditions whose contract is completed
+// or cancelled are left out. Totals are kept per currency. Private deposits are read from
+// depositCollection; where this peer holds no copy they are counted as offers only, and
+// the note says how many were excluded.
+// Uses a query string to perform a rich query (only supported if CouchDB is used as state database)
+// ===========================================================================================
+func (t *SimpleChaincode) getAggregateDepositByProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	propertyNo, err := parsePositiveInt("property_num", args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	propertyNum := strconv.Itoa(propertyNo)
+	propertyNumAsBytes, _ := json.Marshal(propertyNum)
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"condition\",\"property_num\":%s}}", propertyNumAsBytes)
+	fmt.Printf("- getAggregateDepositByProperty queryString:\n%s\n", queryString)
+
+	offers, err := queryConditions(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+
+	aggregate := depositAggregate{Property_num: propertyNum, TotalDeposit: map[string]int{}}
+	excluded := 0
+	for _, offer := range offers {
+		contractAsBytes, err := findContractForCondition(stub, offer.Condition_num)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		if contractAsBytes != nil {
+			linkedContract := contract{}
+			json.Unmarshal(contractAsBytes, &linkedContract)
+			if linkedContract.Status == "completed" || linkedContract.Status == "cancelled" {
+				continue
+			}
+		}
+		aggregate.OfferCount++
+
+		deposit := offer.Deposit
+		if offer.DepositPrivate {
+			var found bool
+			deposit, found, err = readPrivateDeposit(stub, offer.Condition_num)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			} else if !found {
+				excluded++
+				continue
+			}
+		}
+		aggregate.TotalDeposit[currencyOf(offer)] += deposit
+	}
+	if excluded > 0 {
+		aggregate.Note = fmt.Sprintf("%d private deposit(s) excluded: not readable on this peer", excluded)
+	}
+
+	aggregateAsBytes, err := json.Marshal(aggregate)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(aggregateAsBytes)
+}
+
+// ===========================================================================================
+// getMarketStatsByOwner - per-owner rollup for dashboards: the properties the owner holds
+// (co-owned ones included) and the conditions they offer as seller that are still active,
+// i.e. have no contract yet or one that is neither completed nor cancelled, with the total
+// deposit of those conditions per currency.
+// Properties are counted from the "owner~propertynum" index; conditions need rich query
+// (only supported if CouchDB is used as state database)
+// ===========================================================================================
+func (t *SimpleChaincode) getMarketStatsByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "tom"
+
+	ownerKey := strings.ToLower(strings.TrimSpace(args[0]))
+	if len(ownerKey) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty string")
+	}
+	stats := marketStats{Owner: ownerKey, TotalDeposit: map[string]int{}}
+
+	ownerPropertyResultsIterator, err := stub.GetStateByPartialCompositeKey("owner~propertynum", []string{ownerKey})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer ownerPropertyResultsIterator.Close()
+	for ownerPropertyResultsIterator.HasNext() {
+		if _, err := ownerPropertyResultsIterator.Next(); err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		stats.PropertyCount++
+	}
+
+	ownerAsBytes, _ := json.Marshal(ownerKey)
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"condition\",\"seller\":%s}}", ownerAsBytes)
+	offers, err := queryConditions(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+
+	for _, offer := range offers {
+		contractAsBytes, err := findContractForCondition(stub, offer.Condition_num)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		if contractAsBytes != nil {
+			linkedContract := contract{}
+			json.Unmarshal(contractAsBytes, &linkedContract)
+			if linkedContract.Status == "completed" || linkedContract.Status == "cancelled" {
+				continue
+			}
+		}
+		stats.ActiveConditionCount++
+		if !offer.DepositPrivate {
+			stats.TotalDeposit[currencyOf(offer)] += offer.Deposit
+		}
+	}
+
+	statsAsBytes, err := json.Marshal(stats)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(statsAsBytes)
+}
+
+// ===========================================================================================
+// getOwnershipDurationStats - replays a property's history to find how long each owner held
+// it. Every holding period ends at the next transfer (or deletion); the current owner's period
+// is measured against the transaction timestamp so the result is the same on every endorser.
+// Co-owners are tracked one by one, so a co-owner keeps holding across changes to the others.
+// ===========================================================================================
+func (t *SimpleChaincode) getOwnershipDurationStats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	propertyNum := args[0]
+	fmt.Printf("- start getOwnershipDurationStats: %s\n", propertyNum)
+
+	resultsIterator, err := stub.GetHistoryForKey(propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var revisions []historyRevision
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		revision := historyRevision{
+			timestamp: time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)),
+			isDelete:  response.IsDelete,
+		}
+		if !response.IsDelete {
+			propertyRevision := property{}
+			err = json.Unmarshal(response.Value, &propertyRevision)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			revision.owners = ownerKeysOf(propertyRevision)
+		}
+		revisions = append(revisions, revision)
+	}
+	if len(revisions) == 0 {
+		return errorJSON(errCodeNotFound, "Property does not exist")
+	}
+	sort.SliceStable(revisions, func(i, j int) bool { return revisions[i].timestamp.Before(revisions[j].timestamp) })
+
+	measuredAt, err := txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	now, err := time.Parse(time.RFC3339, measuredAt)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// every co-owner holds from the revision that added them until the one that dropped them
+	stats := ownershipDurationStats{Property_num: propertyNum, Holdings: []ownershipHolding{}}
+	var holders []string
+	since := make(map[string]time.Time)
+	closeHolding := func(holder string, until time.Time, current bool) {
+		stats.Holdings = append(stats.Holdings, ownershipHolding{
+			Owner:           holder,
+			From:            since[holder].UTC().Format(time.RFC3339),
+			DurationSeconds: int64(until.Sub(since[holder]).Seconds()),
+			Current:         current,
+		})
+		delete(since, holder)
+	}
+	for _, revision := range revisions {
+		current := make(map[string]bool)
+		for _, owner := range revision.owners {
+			current[owner] = true
+		}
+		var remaining []string
+		for _, holder := range holders {
+			if current[holder] {
+				remaining = append(remaining, holder)
+			} else {
+				closeHolding(holder, revision.timestamp, false)
+			}
+		}
+		changed := len(remaining) != len(holders)
+		for _, owner := range revision.owners {
+			if _, holding := since[owner]; !holding {
+				since[owner] = revision.timestamp
+				remaining = append(remaining, owner)
+				changed = true
+			}
+		}
+		if changed && len(holders) > 0 && !revision.isDelete {
+			stats.Transfers++
+		}
+		holders = remaining
+	}
+	for _, holder := range holders {
+		closeHolding(holder, now, true)
+	}
+
+	var total int64
+	for _, holding := range stats.Holdings {
+		total += holding.DurationSeconds
+	}
+	if len(stats.Holdings) > 0 {
+		stats.AverageHoldSeconds = total / int64(len(stats.Holdings))
+	}
+
+	statsAsBytes, err := json.Marshal(stats)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end getOwnershipDurationStats")
+	return shim.Success(statsAsBytes)
+}
+
+// ==================================================
+// deleteProperty - remove a property key/value pair from state
+// Only the owner or an admin can delete; a co-owned property needs an admin,
+// since one co-owner cannot remove the others' shares. A property still
+// referenced by a condition is not deleted, so that conditions and contracts
+// are never orphaned.
+// ==================================================
+func (t *SimpleChaincode) deleteProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var propertyJSON property
+	propertyNum := args[0]
+
+	valAsbytes, err := stub.GetState(propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get state for " + propertyNum)
+	} else if valAsbytes == nil {
+		return errorJSON(errCodeNotFound, "Property does not exist: " + propertyNum)
+	}
+
+	err = json.Unmarshal([]byte(valAsbytes), &propertyJSON)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to decode JSON of: " + propertyNum)
+	}
+	if propertyJSON.ObjectType != "property" {
+		return errorJSON(errCodeInvalidArgs, propertyNum + " is a " + propertyJSON.ObjectType + ", not a property")
+	}
+
+	ownerKeys := ownerKeysOf(propertyJSON)
+	if len(ownerKeys) > 1 {
+		ownerKeys = nil
+	}
+	err = requireOwnerOrAdmin(stub, propertyJSON.OwnerMSP, ownerKeys...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	// ==== Refuse the delete while any condition still references the property ====
+	conditionNum, err := findConditionForProperty(stub, propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if conditionNum != "" {
+		return errorJSON(errCodeInvalidState, "Property " + propertyNum + " is still referenced by condition " + conditionNum)
+	}
+
+	// maintain the index
+	for _, ownerKey := range ownerKeysOf(propertyJSON) {
+		err = delOwnerIndex(stub, ownerKey, propertyNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to delete owner index:" + err.Error())
+		}
+	}
+
+	err = stub.DelState(propertyNum) //remove the property from chaincode state
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to delete state:" + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ==================================================
+// deleteCondition - remove an abandoned condition from state
+// Only the seller, the buyer or an admin can delete. A condition a contract
+// was created from is not deleted, so that contracts are never orphaned.
+// A private deposit is deleted with it.
+// ==================================================
+func (t *SimpleChaincode) deleteCondition(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var conditionJSON conditionOfContract
+	conditionNum := args[0]
+
+	valAsbytes, err := stub.GetState(conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get state for " + conditionNum)
+	} else if valAsbytes == nil {
+		return errorJSON(errCodeNotFound, "Condition does not exist: " + conditionNum)
+	}
+
+	err = json.Unmarshal([]byte(valAsbytes), &conditionJSON)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to decode JSON of: " + conditionNum)
+	}
+	if conditionJSON.ObjectType != "condition" {
+		return errorJSON(errCodeInvalidArgs, conditionNum + " is a " + conditionJSON.ObjectType + ", not a condition")
+	}
+
+	err = requireOwnerOrAdmin(stub, "", conditionJSON.Seller, conditionJSON.Buyer)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	// ==== Refuse the delete while a contract still references the condition ====
+	contractAsBytes, err := findContractForCondition(stub, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if contractAsBytes != nil {
+		existing := contract{}
+		json.Unmarshal(contractAsBytes, &existing)
+		return errorJSON(errCodeInvalidState, "Condition " + conditionNum + " is still referenced by contract " + existing.Contract_num)
+	}
+
+	if conditionJSON.DepositPrivate {
+		err = stub.DelPrivateData(depositCollection, conditionNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to delete private deposit:" + err.Error())
+		}
+	}
+
+	err = delIndexEntry(stub, "property~condition", conditionJSON.Property_num, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to delete condition index:" + err.Error())
+	}
+	err = stub.DelState(conditionNum) //remove the condition from chaincode state
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to delete state:" + err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ==================================================
+// deleteContract - archive cleanup of a finished contract
+// Only the seller, the buyer or an admin can delete, and only "completed" and
+// "cancelled" contracts; a pending or signed contract is a deal still in flight.
+// A contract whose condition is gone can only be deleted by an admin.
+// ==================================================
+func (t *SimpleChaincode) deleteContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var contractJSON contract
+	contractNum := args[0]
+
+	valAsbytes, err := stub.GetState(contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get state for " + contractNum)
+	} else if valAsbytes == nil {
+		return errorJSON(errCodeNotFound, "Contract does not exist: " + contractNum)
+	}
+
+	err = json.Unmarshal([]byte(valAsbytes), &contractJSON)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to decode JSON of: " + contractNum)
+	}
+	if contractJSON.ObjectType != "contract" {
+		return errorJSON(errCodeInvalidArgs, contractNum + " is a " + contractJSON.ObjectType + ", not a contract")
+	}
+
+	var parties []string
+	linkedCondition := conditionOfContract{}
+	if getRecord(stub, contractJSON.Condition_num, "condition", &linkedCondition) == nil {
+		parties = []string{linkedCondition.Seller, linkedCondition.Buyer}
+	}
+	err = requireOwnerOrAdmin(stub, "", parties...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	if contractJSON.Status != "completed" && contractJSON.Status != "cancelled" {
+		return errorJSON(errCodeInvalidState, "Contract " + contractNum + " is \"" + contractJSON.Status + "\"; only completed or cancelled contracts can be deleted")
+	}
+
+	err = delIndexEntry(stub, "condition~contract", contractJSON.Condition_num, contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to delete contract index:" + err.Error())
+	}
+	err = stub.DelState(contractNum) //remove the contract from chaincode state
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to delete state:" + err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// getHistoryForProperty - returns every revision of a property, oldest first as the ledger
+// reports them. Deleted revisions carry IsDelete:true and a null Value.
+// ===========================================================================================
+func (t *SimpleChaincode) getHistoryForProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+
+	propertyNum := args[0]
+
+	fmt.Printf("- start getHistoryForProperty: %s\n", propertyNum)
+
+	resultsIterator, err := stub.GetHistoryForKey(propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// buffer is a JSON array containing historic values for the property
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"TxId\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(response.TxId)
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"Value\":")
+		// if it was a delete operation on given key, then we need to set the
+		//corresponding value null. Else, we will write the response.Value
+		//as-is (as the Value itself a JSON property)
+		if response.IsDelete {
+			buffer.WriteString("null")
+		} else {
+			buffer.WriteString(string(response.Value))
+		}
+
+		buffer.WriteString(", \"Timestamp\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).UTC().Format(time.RFC3339))
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"IsDelete\":")
+		buffer.WriteString(strconv.FormatBool(response.IsDelete))
+
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	fmt.Printf("- getHistoryForProperty returning:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ===========================================================================================
+// getHistoryForContract - returns every revision of a contract, oldest first, with the status
+// it had and the Transition from the previous revision: "created" for the first revision,
+// e.g. "pending→signed" when the status changed, "" when it did not (an approval) and
+// "deleted" for a delete marker, whose Value is null.
+// ===========================================================================================
+func (t *SimpleChaincode) getHistoryForContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	contractNum := args[0]
+	fmt.Printf("- start getHistoryForContract: %s\n", contractNum)
+
+	resultsIterator, err := stub.GetHistoryForKey(contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	revisions := []contractRevision{}
+	previousStatus := ""
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		revision := contractRevision{
+			TxId:      response.TxId,
+			Timestamp: time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).UTC().Format(time.RFC3339),
+			IsDelete:  response.IsDelete,
+		}
+
+		if response.IsDelete {
+			revision.Transition = "deleted"
+			previousStatus = ""
+		} else {
+			value := &contract{}
+			err = json.Unmarshal(response.Value, value)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			revision.Value = value
+			revision.Status = value.Status
+			if previousStatus == "" {
+				revision.Transition = "created"
+			} else if previousStatus != value.Status {
+				revision.Transition = previousStatus + "→" + value.Status
+			}
+			previousStatus = value.Status
+		}
+		revisions = append(revisions, revision)
+	}
+
+	revisionsAsBytes, err := json.Marshal(revisions)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(revisionsAsBytes)
+}
+
+// ===========================================================================================
+// getPropertiesByRange performs a range query based on the start and end keys provided.
+// Properties, conditions and contracts share one flat key space, so anything in the range
+// that is not a property is skipped.
+// An optional 3rd argument ("name", "owner" or "property_num") sorts the results in memory
+// before returning them; without it results come back in key order.
+// ===========================================================================================
+func (t *SimpleChaincode) getPropertiesByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0      1      2
+	// "100", "200", "name"
+	startKey := args[0]
+	endKey := args[1]
+	sortField := ""
+	if len(args) > 2 {
+		sortField = args[2]
+		if _, ok := propertySortLess[sortField]; !ok {
+			return errorJSON(errCodeInvalidArgs, "Unknown sort field " + sortField + ". Expecting name, owner or property_num")
+		}
+	}
+
+	config, err := loadConfig(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	if len(sortField) > 0 {
+		return sortedPropertyResponseFromIterator(resultsIterator, sortField, config.MaxQueryResults)
+	}
+
+	buffer, err := constructPropertyResponseFromIterator(resultsIterator, config.MaxQueryResults)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+
+	fmt.Printf("- getPropertiesByRange queryResult:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ===== Example: Parameterized rich query =================================================
+// queryPropertiesByOwner queries for properties based on a passed in owner.
+// This is an example of a parameterized query where the query logic is baked into the chaincode,
+// and accepting a single query parameter (owner).
+// Only available on state databases that support rich query (e.g. CouchDB), unless the
+// optional 2nd argument "scan" allows falling back to a full range scan (see queryWithScanFallback).
+// =========================================================================================
+func (t *SimpleChaincode) queryPropertiesByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0      1 (optional)
+	// "bob", "scan"
+
+	// owners are matched on the lowercased owner_key, co-owners on the owners array
+	ownerKey := strings.ToLower(strings.TrimSpace(args[0]))
+	allowScan, err := parseScanOption(args)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+
+	ownerKeyAsBytes, _ := json.Marshal(ownerKey)
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"property\",\"$or\":[{\"owner_key\":%s},{\"owners\":{\"$elemMatch\":{\"$eq\":%s}}}]}}", ownerKeyAsBytes, ownerKeyAsBytes)
+
+	queryResults, err := queryWithScanFallback(stub, queryString, allowScan, func(valueAsBytes []byte) bool {
+		record := property{}
+		return json.Unmarshal(valueAsBytes, &record) == nil && record.ObjectType == "property" && isOwnerOf(record, ownerKey)
+	})
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// =========================================================================================
+// getQueryResultForQueryString executes the passed in query string.
+// Result set is built and returned as a byte array containing the JSON results.
+// More than the configured maxQueryResults records fail with a tooManyResultsError.
+// =========================================================================================
+func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString string) ([]byte, error) {
+
+	fmt.Printf("- getQueryResultForQueryString queryString:\n%s\n", queryString)
+
+	config, err := loadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := stub.GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := constructQueryResponseFromIterator(resultsIterator, config.MaxQueryResults)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
+
+	return buffer.Bytes(), nil
+}
+
+// queryConditions runs queryString through getQueryResultForQueryString, so the configured
+// maxQueryResults cap applies, and decodes the conditions it matched.
+func queryConditions(stub shim.ChaincodeStubInterface, queryString string) ([]conditionOfContract, error) {
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Record conditionOfContract `json:"Record"`
+	}
+	err = json.Unmarshal(queryResults, &results)
+	if err != nil {
+		return nil, err
+	}
+	conditions := make([]conditionOfContract, len(results))
+	for i, result := range results {
+		conditions[i] = result.Record
+	}
+	return conditions, nil
+}
+
+// parseScanOption reads the optional "scan" argument following a query handler's 1st argument.
+func parseScanOption(args []string) (bool, error) {
+	if len(args) <= 1 {
+		return false, nil
+	}
+	if args[1] != "scan" {
+		return false, fmt.Errorf("Unknown option %s. Expecting scan", args[1])
+	}
+	return true, nil
+}
+
+// queryWithScanFallback runs queryString as a rich query. If that fails, e.g. because the peer
+// uses LevelDB, and allowScan is set, it instead range scans every key and keeps the records
+// match accepts, in the same {"Key","Record"} array. The scan reads the whole key space, so
+// callers only allow it when asked to.
+func queryWithScanFallback(stub shim.ChaincodeStubInterface, queryString string, allowScan bool, match func(valueAsBytes []byte) bool) ([]byte, error) {
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if _, tooMany := err.(tooManyResultsError); err == nil || tooMany || !allowScan {
+		return queryResults, err
+	}
+	fmt.Printf("- rich query failed (%s), falling back to a range scan\n", err)
+
+	config, err := loadConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	matched := 0
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !match(queryResponse.Value) {
+			continue
+		}
+		if matched == config.MaxQueryResults {
+			return nil, tooManyResultsError{config.MaxQueryResults}
+		}
+		matched++
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"Key\":\"")
+		buffer.WriteString(queryResponse.Key)
+		buffer.WriteString("\", \"Record\":")
+		buffer.WriteString(string(queryResponse.Value))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return buffer.Bytes(), nil
+}
+
+// ===========================================================================================
+// ndjsonFromJSONArray rewrites a JSON array response, e.g. one built by
+// constructQueryResponseFromIterator, as newline-delimited JSON: one compacted array member
+// per line. An empty array gives an empty payload.
+// ===========================================================================================
+func ndjsonFromJSONArray(arrayAsBytes []byte) ([]byte, error) {
+	var members []json.RawMessage
+	err := json.Unmarshal(arrayAsBytes, &members)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	for _, member := range members {
+		err = json.Compact(&buffer, member)
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteString("\n")
+	}
+	return buffer.Bytes(), nil
+}
+
+// ===========================================================================================
+// constructQueryResponseFromIterator constructs a JSON array containing query results from
+// a given result iterator. An empty iterator gives "[]", never empty bytes, so every query
+// handler built on it returns a payload clients can parse even on a fresh channel.
+// More than maxResults records fail with a tooManyResultsError; 0 means no limit, for
+// iterators that are already bounded by a page size.
+// ===========================================================================================
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, maxResults int) (*bytes.Buffer, error) {
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	count := 0
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if maxResults > 0 && count == maxResults {
+			return nil, tooManyResultsError{maxResults}
+		}
+		count++
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"Key\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(queryResponse.Key)
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"Record\":")
+		// Record is a JSON object, so we write as-is
+		buffer.WriteString(string(queryResponse.Value))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return &buffer, nil
+}
+
+// ===== Example: Ad hoc rich query ========================================================
+// queryProperties uses a query string to perform a query for properties, conditions or contracts.
+// Query string matching state database syntax is passed in and executed as is.
+// Supports ad hoc queries that can be defined at runtime by the client.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (t *SimpleChaincode) queryProperties(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "queryString"
+	if len(args[0]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty query string")
+	}
+
+	queryString := args[0]
+
+	// ==== Catch the most common mistake before CouchDB returns an opaque error ====
+	var query map[string]interface{}
+	if err := json.Unmarshal([]byte(queryString), &query); err != nil {
+		return errorJSON(errCodeInvalidArgs, "query must be a JSON object: " + err.Error())
+	}
+	if _, ok := query["selector"]; !ok {
+		return errorJSON(errCodeInvalidArgs, "query must contain a 'selector' field")
+	}
+
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		if _, ok := err.(tooManyResultsError); ok {
+			return queryErrorJSON(err)
+		}
+		return errorJSON(errCodeInternal, "Rich query failed (queries require CouchDB as the state database): " + err.Error())
+	}
+	return shim.Success(queryResults)
+}
+
+// ===============================================
+// readProperty - read a property from chaincode state, refusing records of any other docType
+// An optional second argument lists the fields to return, comma-separated (e.g. "owner")
+// ===============================================
+func (t *SimpleChaincode) readProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	propertyNum := args[0]
+	valAsbytes, err := stub.GetState(propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get state for " + propertyNum)
+	} else if valAsbytes == nil {
+		return errorJSON(errCodeNotFound, "Property does not exist: " + propertyNum)
+	}
+
+	propertyJSON := property{}
+	err = json.Unmarshal(valAsbytes, &propertyJSON)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to decode JSON of: " + propertyNum)
+	}
+	if propertyJSON.ObjectType != "property" {
+		return errorJSON(errCodeInvalidArgs, propertyNum + " is a " + propertyJSON.ObjectType + ", not a property")
+	}
+	propertyJSON.OwnerKey = ownerKeyOf(propertyJSON)
+
+	propertyJSONasBytes, err := json.Marshal(propertyJSON)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	if len(args) < 2 || len(args[1]) <= 0 {
+		return shim.Success(propertyJSONasBytes)
+	}
+
+	// ==== Project the requested fields only, e.g. "owner,address" ====
+	var allFields map[string]interface{}
+	err = json.Unmarshal(propertyJSONasBytes, &allFields)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	projection := make(map[string]interface{})
+	for _, field := range strings.Split(args[1], ",") {
+		field = strings.TrimSpace(field)
+		value, ok := allFields[field]
+		if !ok {
+			validFields := make([]string, 0, len(allFields))
+			for name := range allFields {
+				validFields = append(validFields, name)
+			}
+			sort.Strings(validFields)
+			return errorJSON(errCodeInvalidArgs, "Unknown field " + field + ". Expecting any of: " + strings.Join(validFields, ", "))
+		}
+		projection[field] = value
+	}
+
+	projectionAsBytes, err := json.Marshal(projection)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(projectionAsBytes)
+}
+
+// ===========================================================
+// signContract - move a contract from "pending" to "signed"
+// Admin override only; the parties sign through approveContract.
+// ===========================================================
+func (t *SimpleChaincode) signContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "contract1"
+
+	contractNum := args[0]
+	fmt.Println("- start signContract ", contractNum)
+
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	contractAsBytes, err := stub.GetState(contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get contract:" + err.Error())
+	} else if contractAsBytes == nil {
+		return errorJSON(errCodeNotFound, "Contract does not exist")
+	}
+
+	contractToSign := contract{}
+	err = json.Unmarshal(contractAsBytes, &contractToSign)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	if contractToSign.ObjectType != "contract" {
+		return errorJSON(errCodeInvalidArgs, contractNum + " is a " + contractToSign.ObjectType + ", not a contract")
+	}
+	if contractToSign.Status != "pending" {
+		return errorJSON(errCodeInvalidState, "Contract " + contractNum + " cannot be signed from status \"" + contractToSign.Status + "\"")
+	}
+	contractToSign.Status = "signed"
+	contractToSign.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	contractJSONasBytes, _ := json.Marshal(contractToSign)
+	err = stub.PutState(contractNum, contractJSONasBytes) //rewrite the contract
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end signContract (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// approveContract - record the invoker's approval of a pending contract. The invoker,
+// identified by enrollment ID, must be the seller or the buyer of the linked condition.
+// Once both have approved the contract becomes "signed" and a "ContractSigned" event
+// carrying the stored JSON fires.
+// ===========================================================
+func (t *SimpleChaincode) approveContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	contractNum := args[0]
+	fmt.Println("- start approveContract ", contractNum)
+
+	invoker, err := invokerName(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	chain, err := resolveContractChain(stub, contractNum)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	contractToApprove := chain.Contract
+	if invoker != chain.Condition.Seller && invoker != chain.Condition.Buyer {
+		return errorJSON(errCodeUnauthorized, invoker + " is neither the seller nor the buyer of contract " + contractNum)
+	}
+	if contractToApprove.Status != "pending" {
+		return errorJSON(errCodeInvalidState, "Contract " + contractNum + " cannot be approved from status \"" + contractToApprove.Status + "\"")
+	}
+	if contractToApprove.Approvals[invoker] {
+		return errorJSON(errCodeAlreadyExists, invoker + " already approved contract " + contractNum)
+	}
+
+	if contractToApprove.Approvals == nil {
+		contractToApprove.Approvals = make(map[string]bool)
+	}
+	contractToApprove.Approvals[invoker] = true
+	signed := contractToApprove.Approvals[chain.Condition.Seller] && contractToApprove.Approvals[chain.Condition.Buyer]
+	if signed {
+		contractToApprove.Status = "signed"
+	}
+	contractToApprove.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	contractJSONasBytes, err := json.Marshal(contractToApprove)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.PutState(contractNum, contractJSONasBytes) //rewrite the contract
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Emit ContractSigned once the second party has approved ====
+	if signed {
+		err = setEvent(stub, "ContractSigned", contractNum, "contract", contractJSONasBytes)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+	}
+
+	fmt.Println("- end approveContract (success)")
+	return shim.Success(contractJSONasBytes)
+}
+
+// ===========================================================================================
+// getPendingApprovalsForParty - the contracts waiting on a party: pending contracts whose
+// condition names the party as seller or buyer and which the party has not approved yet.
+// Each is returned resolved like getContractChain.
+// Uses a query string to perform a rich query (only supported if CouchDB is used as state database)
+// ===========================================================================================
+func (t *SimpleChaincode) getPendingApprovalsForParty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "jerry"
+
+	party := strings.ToLower(strings.TrimSpace(args[0]))
+	if len(party) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty string")
+	}
+	partyAsBytes, _ := json.Marshal(party)
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"condition\",\"$or\":[{\"seller\":%s},{\"buyer\":%s}]}}", partyAsBytes, partyAsBytes)
+
+	conditions, err := queryConditions(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+
+	pending := []contractChain{}
+	for _, offer := range conditions {
+		contractAsBytes, err := findContractForCondition(stub, offer.Condition_num)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		} else if contractAsBytes == nil {
+			continue
+		}
+		linkedContract := contract{}
+		if json.Unmarshal(contractAsBytes, &linkedContract) != nil || linkedContract.Status != "pending" || linkedContract.Approvals[party] {
+			continue
+		}
+		chain, err := resolveContractChain(stub, linkedContract.Contract_num)
+		if err != nil {
+			continue // broken chain, see getContractChain
+		}
+		pending = append(pending, *chain)
+	}
+
+	pendingAsBytes, err := json.Marshal(pending)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(pendingAsBytes)
+}
+
+// ===== Example: Parameterized rich query ===================================================
+// getContractsInvolvingParty returns every contract whose condition names the party as seller
+// or buyer, each annotated with the party's role, e.g. a "my deals" view.
+// Uses a query string to perform a rich query (only supported if CouchDB is used as state database)
+// ===========================================================================================
+func (t *SimpleChaincode) getContractsInvolvingParty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "jerry"
+
+	party := strings.ToLower(strings.TrimSpace(args[0]))
+	if len(party) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty string")
+	}
+	partyAsBytes, _ := json.Marshal(party)
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"condition\",\"$or\":[{\"seller\":%s},{\"buyer\":%s}]}}", partyAsBytes, partyAsBytes)
+
+	conditions, err := queryConditions(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+
+	contracts := []partyContract{}
+	seen := make(map[string]bool)
+	for _, offer := range conditions {
+		contractAsBytes, err := findContractForCondition(stub, offer.Condition_num)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		} else if contractAsBytes == nil {
+			continue
+		}
+		linked := partyContract{}
+		if json.Unmarshal(contractAsBytes, &linked.contract) != nil || seen[linked.Contract_num] {
+			continue
+		}
+		seen[linked.Contract_num] = true
+
+		switch {
+		case offer.Seller == party && offer.Buyer == party:
+			linked.Role = "both"
+		case offer.Seller == party:
+			linked.Role = "seller"
+		default:
+			linked.Role = "buyer"
+		}
+		contracts = append(contracts, linked)
+	}
+
+	contractsAsBytes, err := json.Marshal(contracts)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(contractsAsBytes)
+}
+
+// ===========================================================
+// amendContract - relink a pending contract to a renegotiated condition on the same property.
+// The replaced condition is appended to amendmentHistory and earlier approvals are cleared,
+// since they were given to the old terms. Only the seller, the buyer or an admin may amend.
+// ===========================================================
+func (t *SimpleChaincode) amendContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0      1
+	// "1", "2"
+
+	contractNum := args[0]
+	conditionNo, err := parsePositiveInt("condition_num", args[1])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	newConditionNum := strconv.Itoa(conditionNo)
+	fmt.Println("- start amendContract ", contractNum, newConditionNum)
+
+	chain, err := resolveContractChain(stub, contractNum)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	contractToAmend := chain.Contract
+	err = requireOwnerOrAdmin(stub, "", chain.Condition.Seller, chain.Condition.Buyer)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if contractToAmend.Status != "pending" {
+		return errorJSON(errCodeInvalidState, "Contract " + contractNum + " cannot be amended from status \"" + contractToAmend.Status + "\"")
+	}
+	if newConditionNum == contractToAmend.Condition_num {
+		return errorJSON(errCodeInvalidArgs, "contract " + contractNum + " already uses condition " + newConditionNum)
+	}
+
+	// ==== The revised condition must exist, be on the same property and be unused ====
+	revisedCondition := conditionOfContract{}
+	err = getRecord(stub, newConditionNum, "condition", &revisedCondition)
+	if err != nil {
+		return errorJSON(errCodeReferenceMissing, "referenced " + err.Error())
+	}
+	if revisedCondition.Property_num != chain.Condition.Property_num {
+		return errorJSON(errCodeInvalidArgs, "condition " + newConditionNum + " is on property " + revisedCondition.Property_num + ", not property " + chain.Condition.Property_num)
+	}
+	existingAsBytes, err := findContractForCondition(stub, newConditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if existingAsBytes != nil {
+		existing := contract{}
+		json.Unmarshal(existingAsBytes, &existing)
+		return errorJSON(errCodeAlreadyExists, "condition " + newConditionNum + " already has contract " + existing.Contract_num)
+	}
+
+	err = delIndexEntry(stub, "condition~contract", contractToAmend.Condition_num, contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = putIndexEntry(stub, "condition~contract", newConditionNum, contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	contractToAmend.AmendmentHistory = append(contractToAmend.AmendmentHistory, contractToAmend.Condition_num)
+	contractToAmend.Condition_num = newConditionNum
+	contractToAmend.Approvals = nil
+	contractToAmend.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	contractJSONasBytes, err := json.Marshal(contractToAmend)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.PutState(contractNum, contractJSONasBytes) //rewrite the contract
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end amendContract (success)")
+	return shim.Success(contractJSONasBytes)
+}
+
+// ===========================================================
+// cancelContract - cancel a pending or signed contract. The payload reports the deposit
+// held under the linked condition so the caller knows how much to refund.
+// Only the condition's seller, its buyer or an admin may cancel.
+// ===========================================================
+func (t *SimpleChaincode) cancelContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "contract1"
+
+	contractNum := args[0]
+	fmt.Println("- start cancelContract ", contractNum)
+
+	contractAsBytes, err := stub.GetState(contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get contract:" + err.Error())
+	} else if contractAsBytes == nil {
+		return errorJSON(errCodeNotFound, "Contract does not exist")
+	}
+
+	contractToCancel := contract{}
+	err = json.Unmarshal(contractAsBytes, &contractToCancel)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	if contractToCancel.ObjectType != "contract" {
+		return errorJSON(errCodeInvalidArgs, contractNum + " is a " + contractToCancel.ObjectType + ", not a contract")
+	}
+	if contractToCancel.Status != "pending" && contractToCancel.Status != "signed" {
+		return errorJSON(errCodeInvalidState, "Contract " + contractNum + " cannot be cancelled from status \"" + contractToCancel.Status + "\"")
+	}
+
+	conditionAsBytes, err := stub.GetState(contractToCancel.Condition_num)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get condition:" + err.Error())
+	} else if conditionAsBytes == nil {
+		return errorJSON(errCodeReferenceMissing, "Condition " + contractToCancel.Condition_num + " of contract " + contractNum + " does not exist")
+	}
+	linkedCondition := conditionOfContract{}
+	err = json.Unmarshal(conditionAsBytes, &linkedCondition)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = requireOwnerOrAdmin(stub, "", linkedCondition.Seller, linkedCondition.Buyer)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	contractToCancel.Status = "cancelled"
+	contractToCancel.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = delIndexEntry(stub, "property~activecontract", linkedCondition.Property_num, contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	contractJSONasBytes, _ := json.Marshal(contractToCancel)
+	err = stub.PutState(contractNum, contractJSONasBytes) //rewrite the contract
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	releasedDeposit := linkedCondition.Deposit
+	if linkedCondition.DepositPrivate {
+		releasedDeposit, _, err = readPrivateDeposit(stub, linkedCondition.Condition_num)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+	}
+
+	cancellationAsBytes, err := json.Marshal(cancellation{contractNum, contractToCancel.Condition_num, releasedDeposit, currencyOf(linkedCondition), linkedCondition.DepositPrivate})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end cancelContract (success)")
+	return shim.Success(cancellationAsBytes)
+}
+
+// ===== Example: Parameterized rich query =================================================
+// getConditionsByProperty queries for every condition offered on a property, e.g. the
+// competing offers of several buyers on one listing.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (t *SimpleChaincode) getConditionsByProperty(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	propertyNo, err := parsePositiveInt("property_num", args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	propertyNum := strconv.Itoa(propertyNo)
+
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"condition\",\"property_num\":\"%s\"}}", propertyNum)
+
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ===== Example: Parameterized rich query =================================================
+// getConditionsBySeller and getConditionsByBuyer query for every condition in which a party
+// is the seller or the buyer respectively.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// Index each party field to avoid a full scan, e.g. with Fauxton:
+// {"index":{"fields":["docType","seller"]},"ddoc":"indexSellerDoc", "name":"indexSeller","type":"json"}
+// {"index":{"fields":["docType","buyer"]},"ddoc":"indexBuyerDoc", "name":"indexBuyer","type":"json"}
+// =========================================================================================
+func (t *SimpleChaincode) getConditionsBySeller(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "tom"
+	return queryConditionsByParty(stub, "seller", args[0])
+}
+
+func (t *SimpleChaincode) getConditionsByBuyer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "jerry"
+	return queryConditionsByParty(stub, "buyer", args[0])
+}
+
+// queryConditionsByParty returns the conditions whose field ("seller" or "buyer") is party.
+// Parties are stored trimmed and lowercased by initConditon, so party is normalized the same way.
+func queryConditionsByParty(stub shim.ChaincodeStubInterface, field string, party string) pb.Response {
+	party = strings.ToLower(strings.TrimSpace(party))
+	if len(party) <= 0 {
+		return errorJSON(errCodeInvalidArgs, field + " must be a non-empty string")
+	}
+
+	partyAsBytes, _ := json.Marshal(party)
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"condition\",\"%s\":%s}}", field, partyAsBytes)
+
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ===== Example: Parameterized rich query =================================================
+// queryContractsByStatus queries for every contract in a status, e.g. all pending contracts
+// still waiting on approvals.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// Index docType and status to avoid a full scan, e.g. with Fauxton:
+// {"index":{"fields":["docType","status"]},"ddoc":"indexStatusDoc", "name":"indexStatus","type":"json"}
+// =========================================================================================
+func (t *SimpleChaincode) queryContractsByStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "pending"
+
+	status := strings.ToLower(strings.TrimSpace(args[0]))
+	valid := false
+	for _, validStatus := range validContractStatuses {
+		if status == validStatus {
+			valid = true
+		}
+	}
+	if !valid {
+		return errorJSON(errCodeInvalidArgs, "Unknown status " + status + ". Expecting one of: " + strings.Join(validContractStatuses, ", "))
+	}
+
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"contract\",\"status\":\"%s\"}}", status)
+
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// =========================================================================================
+// getConditionWithContractStatus returns a condition with a contractStatus field: "none"
+// when no contract has been created from it yet, otherwise that contract's status.
+// =========================================================================================
+func (t *SimpleChaincode) getConditionWithContractStatus(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	conditionNum := args[0]
+	result := conditionWithContractStatus{ContractStatus: "none"}
+	err := getRecord(stub, conditionNum, "condition", &result.conditionOfContract)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+
+	contractAsBytes, err := findContractForCondition(stub, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if contractAsBytes != nil {
+		linkedContract := contract{}
+		err = json.Unmarshal(contractAsBytes, &linkedContract)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		result.ContractStatus = linkedContract.Status
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// =========================================================================================
+// getContractForCondition returns the contract created from a condition, if any, so a UI can
+// show whether a negotiated condition has progressed into a contract.
+// =========================================================================================
+func (t *SimpleChaincode) getContractForCondition(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	conditionNo, err := parsePositiveInt("condition_num", args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	conditionNum := strconv.Itoa(conditionNo)
+
+	contractAsBytes, err := findContractForCondition(stub, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if contractAsBytes == nil {
+		return errorJSON(errCodeNotFound, "No contract yet for condition " + conditionNum)
+	}
+	return shim.Success(contractAsBytes)
+}
+
+// =========================================================================================
+// findContractForCondition returns the stored contract referencing conditionNum,
+// or nil if no contract has been created from that condition. It follows the
+// "condition~contract" index, which every handler creating, relinking or deleting a
+// contract keeps in the same transaction; entries that no longer resolve are skipped.
+// =========================================================================================
+func findContractForCondition(stub shim.ChaincodeStubInterface, conditionNum string) ([]byte, error) {
+	contractNums, err := indexEntries(stub, "condition~contract", conditionNum)
+	if err != nil {
+		return nil, err
+	}
+	for _, contractNum := range contractNums {
+		contractAsBytes, err := stub.GetState(contractNum)
+		if err != nil {
+			return nil, err
+		}
+		linkedContract := contract{}
+		if contractAsBytes != nil && json.Unmarshal(contractAsBytes, &linkedContract) == nil && linkedContract.ObjectType == "contract" && linkedContract.Condition_num == conditionNum {
+			return contractAsBytes, nil
+		}
+	}
+	return nil, nil
+}
+
+// ===========================================================================================
+// constructPropertyResponseFromIterator is constructQueryResponseFromIterator for iterators over
+// the flat key space: records that are not properties are left out of the JSON array, and
+// only properties count towards maxResults.
+// ===========================================================================================
+func constructPropertyResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, maxResults int) (*bytes.Buffer, error) {
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	count := 0
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var record property
+		if json.Unmarshal(queryResponse.Value, &record) != nil || record.ObjectType != "property" {
+			continue
+		}
+		if maxResults > 0 && count == maxResults {
+			return nil, tooManyResultsError{maxResults}
+		}
+		count++
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"Key\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(queryResponse.Key)
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"Record\":")
+		// Record is a JSON object, so we write as-is
+		buffer.WriteString(string(queryResponse.Value))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return &buffer, nil
+}
+
+// propertySortLess orders two properties by one of the supported sort fields.
+// property_num compares numerically, so "9" sorts before "10".
+var propertySortLess = map[string]func(a, b property) bool{
+	"name":  func(a, b property) bool { return a.Name < b.Name },
+	"owner": func(a, b property) bool { return ownerKeyOf(a) < ownerKeyOf(b) },
+	"property_num": func(a, b property) bool {
+		if len(a.Property_num) != len(b.Property_num) {
+			return len(a.Property_num) < len(b.Property_num)
+		}
+		return a.Property_num < b.Property_num
+	},
+}
+
+// ===========================================================================================
+// sortedPropertyResponseFromIterator collects every property from the iterator, sorts them
+// by sortField and returns them as a [{Key, Record}] array. Ties keep their key order.
+// Sorting needs every property in memory, so more than maxResults fail with TOO_MANY_RESULTS.
+// ===========================================================================================
+func sortedPropertyResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, sortField string, maxResults int) pb.Response {
+	type keyedProperty struct {
+		Key		string		`json:"Key"`
+		Record	property	`json:"Record"`
+	}
+
+	var results []keyedProperty
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		var record property
+		if json.Unmarshal(queryResponse.Value, &record) != nil || record.ObjectType != "property" {
+			continue
+		}
+		if maxResults > 0 && len(results) == maxResults {
+			return queryErrorJSON(tooManyResultsError{maxResults})
+		}
+		results = append(results, keyedProperty{queryResponse.Key, record})
+	}
+
+	less := propertySortLess[sortField]
+	sort.SliceStable(results, func(i, j int) bool { return less(results[i].Record, results[j].Record) })
+
+	if results == nil {
+		results = []keyedProperty{}
+	}
+	resultsAsBytes, err := json.Marshal(results)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(resultsAsBytes)
+}
+
+// ====== Pagination =========================================================================
+// getPropertiesByRangeWithPagination performs a range query based on the start & end key,
+// page size and a bookmark. The bookmark returned in the metadata is passed as-is to fetch
+// the next page; an empty bookmark starts from the beginning of the range.
+// RecordsCount is the number of keys the page scanned, which can exceed the number of
+// properties returned when conditions or contracts fall inside the range.
+// ===========================================================================================
+func (t *SimpleChaincode) getPropertiesByRangeWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//  0    1    2    3
+	// "1", "9", "2", ""
+
+	startKey := args[0]
+	endKey := args[1]
+
+	pageSize, err := strconv.ParseInt(args[2], 10, 32)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "3rd argument must be a numeric string")
+	} else if pageSize <= 0 {
+		return errorJSON(errCodeInvalidArgs, "3rd argument must be a positive number")
+	}
+	bookmark := args[3]
+	err = validateRangeBookmark(bookmark, startKey, endKey)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+
+	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, int32(pageSize), bookmark)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := constructPropertyResponseFromIterator(resultsIterator, 0)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata, int32(pageSize))
+
+	fmt.Printf("- getPropertiesByRangeWithPagination queryResult:\n%s\n", bufferWithPaginationInfo.String())
+
+	return shim.Success(bufferWithPaginationInfo.Bytes())
+}
+
+// ====== Pagination =========================================================================
+// getPropertiesModifiedSince returns the properties whose latest write, taken from the key
+// history, is at or after an RFC3339 time, each with its LastModified timestamp.
+// This is expensive: every property costs a history lookup, so the key space is scanned one
+// page at a time. Pass the returned bookmark back for the next page until it comes back
+// empty; a page may hold no matches at all. Requires the history database to be enabled.
+// ===========================================================================================
+func (t *SimpleChaincode) getPropertiesModifiedSince(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                       1     2
+	// "2026-01-01T00:00:00Z", "50", ""
+	since, err := time.Parse(time.RFC3339, args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be an RFC3339 timestamp")
+	}
+	pageSize, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a numeric string")
+	} else if pageSize <= 0 {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a positive number")
+	}
+	bookmark := args[2]
+	err = validateRangeBookmark(bookmark, "", "")
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+
+	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination("", "", int32(pageSize), bookmark)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var candidates []modifiedProperty
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		var record property
+		if json.Unmarshal(queryResponse.Value, &record) != nil || record.ObjectType != "property" {
+			continue
+		}
+		candidates = append(candidates, modifiedProperty{Key: queryResponse.Key, Record: record})
+	}
+
+	matches := []modifiedProperty{}
+	for _, candidate := range candidates {
+		historyIterator, err := stub.GetHistoryForKey(candidate.Key)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		var lastModified time.Time
+		for historyIterator.HasNext() {
+			response, err := historyIterator.Next()
+			if err != nil {
+				historyIterator.Close()
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			modified := time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos))
+			if modified.After(lastModified) {
+				lastModified = modified
+			}
+		}
+		historyIterator.Close()
+
+		if !lastModified.Before(since) {
+			candidate.LastModified = lastModified.UTC().Format(time.RFC3339)
+			matches = append(matches, candidate)
+		}
+	}
+
+	matchesAsBytes, err := json.Marshal(matches)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(bytes.NewBuffer(matchesAsBytes), responseMetadata, int32(pageSize))
+	return shim.Success(bufferWithPaginationInfo.Bytes())
+}
+
+// validateRangeBookmark checks that a range query bookmark, which is the key the next page
+// starts at, lies inside [startKey, endKey). An empty bookmark starts from the beginning.
+func validateRangeBookmark(bookmark string, startKey string, endKey string) error {
+	if bookmark == "" {
+		return nil
+	}
+	if bookmark < startKey || (endKey != "" && bookmark >= endKey) {
+		return fmt.Errorf("bookmark %s is not from a query over this range", bookmark)
+	}
+	return nil
+}
+
+// queryBookmarkPattern matches CouchDB bookmarks, which are base64url tokens.
+var queryBookmarkPattern = regexp.MustCompile(`^[A-Za-z0-9_\-=]+$`)
+
+// validateQueryBookmark rejects rich query bookmarks that cannot have come from CouchDB.
+// An empty bookmark starts from the beginning.
+func validateQueryBookmark(bookmark string) error {
+	if bookmark != "" && !queryBookmarkPattern.MatchString(bookmark) {
+		return fmt.Errorf("bookmark is not a valid query bookmark")
+	}
+	return nil
+}
+
+// ===========================================================================================
+// addPaginationMetadataToQueryResults wraps a JSON array of query results together with the
+// page's response metadata: {"Results":[...], "ResponseMetadata":{"RecordsCount":n, "Bookmark":"..."}}
+// A page shorter than pageSize is the last one, so its Bookmark is "" as a clean stop condition.
+// ===========================================================================================
+func addPaginationMetadataToQueryResults(buffer *bytes.Buffer, responseMetadata *pb.QueryResponseMetadata, pageSize int32) *bytes.Buffer {
+	bookmark := responseMetadata.Bookmark
+	if responseMetadata.FetchedRecordsCount < pageSize {
+		bookmark = ""
+	}
+
+	var wrapped bytes.Buffer
+	wrapped.WriteString("{\"Results\":")
+	wrapped.Write(buffer.Bytes())
+
+	wrapped.WriteString(", \"ResponseMetadata\":{\"RecordsCount\":")
+	wrapped.WriteString(fmt.Sprintf("%v", responseMetadata.FetchedRecordsCount))
+	wrapped.WriteString(", \"Bookmark\":")
+	bookmarkAsBytes, _ := json.Marshal(bookmark)
+	wrapped.Write(bookmarkAsBytes)
+	wrapped.WriteString("}}")
+
+	return &wrapped
+}
+
+// ===== Example: Pagination with Ad hoc Rich Query ========================================
+// queryPropertiesWithPagination uses a query string, page size and a bookmark to perform a query
+// for properties. Query string matching state database syntax is passed in and executed as is.
+// The number of fetched records would be equal to or lesser than the specified page size.
+// Supports ad hoc queries that can be defined at runtime by the client.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// Paginated queries are only valid for read only transactions.
+// =========================================================================================
+func (t *SimpleChaincode) queryPropertiesWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0             1      2
+	// "queryString", "10", "bookmark"
+	if len(args[0]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty query string")
+	}
+
+	queryString := args[0]
+
+	pageSize, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a numeric string")
+	} else if pageSize <= 0 {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a positive number")
+	}
+	bookmark := args[2]
+	err = validateQueryBookmark(bookmark)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, int32(pageSize), bookmark)
+	if err != nil {
+		return errorJSON(errCodeInternal, "pagination rich queries require CouchDB: " + err.Error())
+	}
+	return shim.Success(queryResults)
+}
+
+// =========================================================================================
+// getQueryResultForQueryStringWithPagination executes the passed in query string with
+// pagination info. Result set is built and returned as a byte array containing the JSON results.
+// =========================================================================================
+func getQueryResultForQueryStringWithPagination(stub shim.ChaincodeStubInterface, queryString string, pageSize int32, bookmark string) ([]byte, error) {
+
+	fmt.Printf("- getQueryResultForQueryString queryString:\n%s\n", queryString)
+
+	resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(queryString, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	buffer, err := constructQueryResponseFromIterator(resultsIterator, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferWithPaginationInfo := addPaginationMetadataToQueryResults(buffer, responseMetadata, pageSize)
+
+	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", bufferWithPaginationInfo.String())
+
+	return bufferWithPaginationInfo.Bytes(), nil
+}
+
+// ===========================================================================================
+// putOwnerIndex and delOwnerIndex maintain the "owner~propertynum" composite key index.
+// An index entry is a composite key with a placeholder value: only the key name is needed,
+// and passing a nil value would effectively delete the key from state.
+// ===========================================================================================
+func putOwnerIndex(stub shim.ChaincodeStubInterface, owner string, propertyNum string) error {
+	ownerPropertyIndexKey, err := stub.CreateCompositeKey("owner~propertynum", []string{owner, propertyNum})
+	if err != nil {
+		return err
+	}
+	value := []byte{0x00}
+	return stub.PutState(ownerPropertyIndexKey, value)
+}
+
+func delOwnerIndex(stub shim.ChaincodeStubInterface, owner string, propertyNum string) error {
+	ownerPropertyIndexKey, err := stub.CreateCompositeKey("owner~propertynum", []string{owner, propertyNum})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(ownerPropertyIndexKey)
+}
+
+// ===========================================================================================
+// putIndexEntry and delIndexEntry maintain the composite key indexes linking records, e.g.
+// "condition~contract" from a condition to the contract created from it. Checks read them
+// with GetStateByPartialCompositeKey: Fabric re-validates range reads at commit, so two
+// concurrent transactions cannot both pass a check, and it works on LevelDB too. Rich query
+// results are neither re-validated nor available on LevelDB.
+// ===========================================================================================
+func putIndexEntry(stub shim.ChaincodeStubInterface, indexName string, key string, value string) error {
+	indexKey, err := stub.CreateCompositeKey(indexName, []string{key, value})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+func delIndexEntry(stub shim.ChaincodeStubInterface, indexName string, key string, value string) error {
+	indexKey, err := stub.CreateCompositeKey(indexName, []string{key, value})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+// indexEntries returns the values indexed under key, in key order.
+func indexEntries(stub shim.ChaincodeStubInterface, indexName string, key string) ([]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var values []string
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, compositeKeyParts[1])
+	}
+	return values, nil
+}
+
+// ===========================================================================================
+// getPropertiesByOwnerIndexed returns all properties of an owner by walking the
+// "owner~propertynum" index with a partial composite key query. Unlike queryPropertiesByOwner
+// this works on LevelDB as well as CouchDB. More than maxQueryResults fail with TOO_MANY_RESULTS.
+// ===========================================================================================
+func (t *SimpleChaincode) getPropertiesByOwnerIndexed(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "bob"
+
+	ownerKey := strings.ToLower(strings.TrimSpace(args[0]))
+	fmt.Println("- start getPropertiesByOwnerIndexed ", ownerKey)
+
+	config, err := loadConfig(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// Query the owner~propertynum index by owner
+	// This will execute a key range query on all keys starting with 'owner'
+	ownerPropertyResultsIterator, err := stub.GetStateByPartialCompositeKey("owner~propertynum", []string{ownerKey})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer ownerPropertyResultsIterator.Close()
+
+	// buffer is a JSON array containing QueryResults
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	count := 0
+	bArrayMemberAlreadyWritten := false
+	for ownerPropertyResultsIterator.HasNext() {
+		responseRange, err := ownerPropertyResultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+
+		// get the owner and property number from the composite key
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		propertyNum := compositeKeyParts[1]
+
+		propertyAsBytes, err := stub.GetState(propertyNum)
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to get property:" + err.Error())
+		} else if propertyAsBytes == nil {
+			continue // dangling index entry
+		}
+		if count == config.MaxQueryResults {
+			return queryErrorJSON(tooManyResultsError{config.MaxQueryResults})
+		}
+		count++
+
+		// Add a comma before array members, suppress it for the first array member
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString("{\"Key\":")
+		buffer.WriteString("\"")
+		buffer.WriteString(propertyNum)
+		buffer.WriteString("\"")
+
+		buffer.WriteString(", \"Record\":")
+		// Record is a JSON object, so we write as-is
+		buffer.WriteString(string(propertyAsBytes))
+		buffer.WriteString("}")
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	fmt.Printf("- getPropertiesByOwnerIndexed queryResult:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
+
+// ===========================================================
+// updateDeposit - amend the deposit of a condition while it is still being negotiated.
+// Once a contract exists for the condition its terms are locked. Only the seller, the
+// buyer or an admin may amend it.
+// ===========================================================
+func (t *SimpleChaincode) updateDeposit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0      1
+	// "1", "5000"
+
+	conditionNo, err := parsePositiveInt("condition_num", args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	conditionNum := strconv.Itoa(conditionNo)
+	newDeposit, err := parsePositiveInt("deposit", args[1])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	if err = checkMaxDeposit(stub, newDeposit); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	fmt.Println("- start updateDeposit ", conditionNum, newDeposit)
+
+	conditionAsBytes, err := stub.GetState(conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get condition:" + err.Error())
+	} else if conditionAsBytes == nil {
+		return errorJSON(errCodeNotFound, "Condition does not exist")
+	}
+
+	conditionToUpdate := conditionOfContract{}
+	err = json.Unmarshal(conditionAsBytes, &conditionToUpdate)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	if conditionToUpdate.ObjectType != "condition" {
+		return errorJSON(errCodeInvalidArgs, conditionNum + " is a " + conditionToUpdate.ObjectType + ", not a condition")
+	}
+	err = requireOwnerOrAdmin(stub, "", conditionToUpdate.Seller, conditionToUpdate.Buyer)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	contractAsBytes, err := findContractForCondition(stub, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	} else if contractAsBytes != nil {
+		existing := contract{}
+		json.Unmarshal(contractAsBytes, &existing)
+		return errorJSON(errCodeInvalidState, "Condition " + conditionNum + " is locked by contract " + existing.Contract_num)
+	}
+
+	if conditionToUpdate.DepositPrivate {
+		return errorJSON(errCodeInvalidState, "Condition " + conditionNum + " has a private deposit")
+	}
+	if conditionToUpdate.DepositPaid {
+		return errorJSON(errCodeInvalidState, "Condition " + conditionNum + " has its deposit paid already")
+	}
+
+	change := depositChange{conditionNum, conditionToUpdate.Deposit, newDeposit}
+	conditionToUpdate.Deposit = newDeposit
+	conditionToUpdate.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	conditionJSONasBytes, _ := json.Marshal(conditionToUpdate)
+	err = stub.PutState(conditionNum, conditionJSONasBytes) //rewrite the condition
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	changeAsBytes, err := json.Marshal(change)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end updateDeposit (success)")
+	return shim.Success(changeAsBytes)
+}
+
+// ===== Example: Parameterized rich query =================================================
+// getAllRecordsByType returns every record of one docType, for reconciliation dumps.
+// Only available on state databases that support rich query (e.g. CouchDB), unless the
+// optional 2nd argument "scan" allows falling back to a full range scan (see queryWithScanFallback).
+// =========================================================================================
+func (t *SimpleChaincode) getAllRecordsByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0           1 (optional)
+	// "property", "scan"
+
+	docType := strings.ToLower(args[0])
+	if err := checkDocType(stub, docType); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	allowScan, err := parseScanOption(args)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"%s\"}}", docType)
+
+	queryResults, err := queryWithScanFallback(stub, queryString, allowScan, func(valueAsBytes []byte) bool {
+		var header struct {
+			ObjectType string `json:"docType"`
+		}
+		return json.Unmarshal(valueAsBytes, &header) == nil && header.ObjectType == docType
+	})
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// isValidDocType reports whether docType is one of validDocTypes
+func isValidDocType(docType string) bool {
+	for _, valid := range validDocTypes {
+		if docType == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDocType fails unless docType is one of the configured docTypes.
+func checkDocType(stub shim.ChaincodeStubInterface, docType string) error {
+	config, err := loadConfig(stub)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range config.DocTypes {
+		if docType == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown docType %s. Expecting one of: %s", docType, strings.Join(config.DocTypes, ", "))
+}
+
+// isValidCurrency reports whether currency is one of validCurrencies.
+func isValidCurrency(currency string) bool {
+	for _, valid := range validCurrencies {
+		if currency == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// currencyOf returns the currency of c's deposit, or unspecifiedCurrency for conditions
+// stored before currencies existed.
+func currencyOf(c conditionOfContract) string {
+	if c.Currency == "" {
+		return unspecifiedCurrency
+	}
+	return c.Currency
+}
+
+// ownerKeyOf returns the normalized owner of p. Properties stored before owner_key existed
+// only have an owner, which was lowercased at the time.
+func ownerKeyOf(p property) string {
+	if p.OwnerKey != "" {
+		return p.OwnerKey
+	}
+	return strings.ToLower(p.Owner)
+}
+
+// ownerKeysOf returns the normalized keys of every owner of p, the primary owner first.
+func ownerKeysOf(p property) []string {
+	if len(p.Owners) > 0 {
+		return p.Owners
+	}
+	return []string{ownerKeyOf(p)}
+}
+
+// checkExpectedVersion fails unless expected, a numeric string, is the stored version of p.
+// Properties written before versioning have version 0.
+func checkExpectedVersion(p property, expected string) error {
+	version, err := strconv.Atoi(strings.TrimSpace(expected))
+	if err != nil {
+		return fmt.Errorf("expected version must be a numeric string")
+	}
+	if version != p.Version {
+		return fmt.Errorf("property %s is at version %d, not %d; re-read it and retry", p.Property_num, p.Version, version)
+	}
+	return nil
+}
+
+// isOwnerOf reports whether ownerKey is one of the owners of p.
+func isOwnerOf(p property, ownerKey string) bool {
+	for _, key := range ownerKeysOf(p) {
+		if key == ownerKey {
+			return true
+		}
+	}
+	return false
+}
+
+// ===========================================================================================
+// getContractChain - resolve a deal in one call: the contract, the condition it was created
+// from and the property that condition is offered on, returned as one nested object.
+// ===========================================================================================
+func (t *SimpleChaincode) getContractChain(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	contractNo, err := parsePositiveInt("contract_num", args[0])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+
+	chain, err := resolveContractChain(stub, strconv.Itoa(contractNo))
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+
+	chainAsBytes, err := json.Marshal(chain)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(chainAsBytes)
+}
+
+// ===========================================================================================
+// readContract - read a contract with the condition it references embedded under "condition".
+// A missing or unreadable condition does not fail the read: "condition" is null and "note"
+// says why, so contracts with broken references can still be inspected.
+// ===========================================================================================
+func (t *SimpleChaincode) readContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	result := contractWithCondition{}
+	err := getRecord(stub, args[0], "contract", &result.contract)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+
+	linkedCondition := conditionOfContract{}
+	err = getRecord(stub, result.Condition_num, "condition", &linkedCondition)
+	if err != nil {
+		result.Note = "referenced condition is unavailable: " + err.Error()
+	} else {
+		result.Condition = &linkedCondition
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ===========================================================================================
+// readConditionPrivate - read a condition with its private deposit filled in from
+// depositCollection. Only peers of organizations in the collection hold the deposit.
+// ===========================================================================================
+func (t *SimpleChaincode) readConditionPrivate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	conditionNum := args[0]
+	privateCondition := conditionOfContract{}
+	err := getRecord(stub, conditionNum, "condition", &privateCondition)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	if !privateCondition.DepositPrivate {
+		return errorJSON(errCodeInvalidArgs, "Condition " + conditionNum + " has a public deposit, use readValue")
+	}
+
+	depositAsBytes, err := stub.GetPrivateData(depositCollection, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get private deposit:" + err.Error())
+	} else if depositAsBytes == nil {
+		return errorJSON(errCodeNotFound, "Private deposit does not exist: " + conditionNum)
+	}
+	privateDeposit := conditionDeposit{}
+	err = json.Unmarshal(depositAsBytes, &privateDeposit)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	privateCondition.Deposit = privateDeposit.Deposit
+
+	conditionAsBytes, err := json.Marshal(privateCondition)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(conditionAsBytes)
+}
+
+// readPrivateDeposit reads a condition's deposit from depositCollection. found is false when
+// this peer holds no copy, e.g. because its organization is not a member of the collection.
+func readPrivateDeposit(stub shim.ChaincodeStubInterface, conditionNum string) (int, bool, error) {
+	depositAsBytes, err := stub.GetPrivateData(depositCollection, conditionNum)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get private deposit: %s", err)
+	} else if depositAsBytes == nil {
+		return 0, false, nil
+	}
+	deposit := conditionDeposit{}
+	err = json.Unmarshal(depositAsBytes, &deposit)
+	if err != nil {
+		return 0, false, err
+	}
+	return deposit.Deposit, true, nil
+}
+
+// ===========================================================================================
+// getConditionDepositHash - return the hex-encoded hash of a condition's private deposit
+// record. Every peer of the channel holds the hash, so a party outside depositCollection can
+// check it against the hash of the value it agreed to without the deposit being disclosed.
+// ===========================================================================================
+func (t *SimpleChaincode) getConditionDepositHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	conditionNum := args[0]
+	hashAsBytes, err := stub.GetPrivateDataHash(depositCollection, conditionNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, "Failed to get private deposit hash:" + err.Error())
+	} else if hashAsBytes == nil {
+		return errorJSON(errCodeNotFound, "Private deposit does not exist: " + conditionNum)
+	}
+	return shim.Success([]byte(hex.EncodeToString(hashAsBytes)))
+}
+
+// resolveContractChain loads a contract and follows condition_num and property_num,
+// naming the first link that is missing.
+func resolveContractChain(stub shim.ChaincodeStubInterface, contractNum string) (*contractChain, error) {
+	chain := &contractChain{}
+
+	err := getRecord(stub, contractNum, "contract", &chain.Contract)
+	if err != nil {
+		return nil, err
+	}
+	err = getRecord(stub, chain.Contract.Condition_num, "condition", &chain.Condition)
+	if err != nil {
+		return nil, fmt.Errorf("contract %s is broken: %s", contractNum, err)
+	}
+	err = getRecord(stub, chain.Condition.Property_num, "property", &chain.Property)
+	if err != nil {
+		return nil, fmt.Errorf("contract %s is broken: %s", contractNum, err)
+	}
+	return chain, nil
+}
+
+// getRecord reads key from state into record and checks it carries the expected docType.
+func getRecord(stub shim.ChaincodeStubInterface, key string, docType string, record interface{}) error {
+	valAsbytes, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s: %s", docType, key, err)
+	} else if valAsbytes == nil {
+		return fmt.Errorf("%s %s does not exist", docType, key)
+	}
+
+	var header struct {
+		ObjectType string `json:"docType"`
+	}
+	if err = json.Unmarshal(valAsbytes, &header); err != nil {
+		return fmt.Errorf("failed to decode JSON of %s: %s", key, err)
+	}
+	if header.ObjectType != docType {
+		return fmt.Errorf("%s is a %s, not a %s", key, header.ObjectType, docType)
+	}
+	return json.Unmarshal(valAsbytes, record)
+}
+
+// parsePositiveInt parses a numeric argument, ignoring surrounding whitespace, and
+// requires it to be greater than zero. name identifies the argument in the error.
+func parsePositiveInt(name string, value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a numeric string", name)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	return n, nil
+}
+
+// checkLength fails when value is longer than max characters. name identifies the field
+// in the error.
+func checkLength(name string, value string, max int) error {
+	if utf8.RuneCountInString(value) > max {
+		return fmt.Errorf("%s must be at most %d characters", name, max)
+	}
+	return nil
+}
+
+// ===== Example: Parameterized rich query =================================================
+// queryConditionsByDepositRange queries for conditions whose deposit lies between min and max
+// (inclusive), so buyers can filter listings by the deposit they can afford.
+// Only available on state databases that support rich query (e.g. CouchDB)
+//
+// Without an index this is a full scan. Create one on docType and deposit, e.g. with Fauxton:
+// {"index":{"fields":["docType","deposit"]},"ddoc":"indexDepositDoc", "name":"indexDeposit","type":"json"}
+// =========================================================================================
+func (t *SimpleChaincode) queryConditionsByDepositRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0       1
+	// "1000", "5000"
+
+	minDeposit, err := strconv.Atoi(strings.TrimSpace(args[0]))
+	if err != nil || minDeposit < 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-negative integer")
+	}
+	maxDeposit, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil || maxDeposit < 0 {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be a non-negative integer")
+	}
+	if minDeposit > maxDeposit {
+		return errorJSON(errCodeInvalidArgs, "minimum deposit must not exceed maximum deposit")
+	}
+
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"condition\",\"deposit\":{\"$gte\":%d,\"$lte\":%d}}}", minDeposit, maxDeposit)
+
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ===========================================================================================
+// getPropertyOwnerHistory - the chain of owners of a property over time. Revisions that did
+// not change the owners, co-owners included, are dropped, and a deleted property ends with
+// an IsDelete entry.
+// ===========================================================================================
+func (t *SimpleChaincode) getPropertyOwnerHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+
+	propertyNum := args[0]
+
+	fmt.Printf("- start getPropertyOwnerHistory: %s\n", propertyNum)
+
+	resultsIterator, err := stub.GetHistoryForKey(propertyNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	changes := []ownerChange{}
+	lastOwnerKeys := ""
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		change := ownerChange{
+			TxId:      response.TxId,
+			Timestamp: time.Unix(response.Timestamp.Seconds, int64(response.Timestamp.Nanos)).UTC().Format(time.RFC3339),
+		}
+		if response.IsDelete {
+			change.IsDelete = true
+			changes = append(changes, change)
+			lastOwnerKeys = ""
+			continue
+		}
+
+		revision := property{}
+		err = json.Unmarshal(response.Value, &revision)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		ownerKeys := strings.Join(ownerKeysOf(revision), ",")
+		if ownerKeys == lastOwnerKeys {
+			continue // same owners, not a transfer
+		}
+		lastOwnerKeys = ownerKeys
+		change.Owner = revision.Owner
+		change.Owners = revision.Owners
+		changes = append(changes, change)
+	}
+
+	changesAsBytes, err := json.Marshal(changes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Printf("- getPropertyOwnerHistory returning:\n%s\n", string(changesAsBytes))
+
+	return shim.Success(changesAsBytes)
+}
+
+// ===============================================
+// getVersion - report the chaincode name and version without touching ledger state,
+// so it also answers on a fresh channel (e.g. CI smoke tests after deployment)
+// ===============================================
+func (t *SimpleChaincode) getVersion(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	versionAsBytes, err := json.Marshal(map[string]string{"name": chaincodeName, "version": chaincodeVersion})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(versionAsBytes)
+}
+
+// ===============================================
+// setConfig - admin-only; store channel-wide parameters, e.g. {"maxDeposit":100000000},
+// so operators can tune them without redeploying. Fields left out keep their defaults.
+// ===============================================
+func (t *SimpleChaincode) setConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "{\"maxDeposit\":100000000,\"listingRoles\":[\"agent\",\"admin\"]}"
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	config := defaultConfig()
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&config)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a config JSON object: " + err.Error())
+	}
+	if config.MaxDeposit < 0 {
+		return errorJSON(errCodeInvalidArgs, "maxDeposit must not be negative")
+	}
+	if len(config.ListingRoles) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "listingRoles must name at least one role")
+	}
+	if config.MaxEventSize <= 0 {
+		return errorJSON(errCodeInvalidArgs, "maxEventSize must be a positive number")
+	}
+	if config.MaxQueryResults <= 0 {
+		return errorJSON(errCodeInvalidArgs, "maxQueryResults must be a positive number")
+	}
+	if len(config.DocTypes) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "docTypes must name at least one docType")
+	}
+	for _, docType := range config.DocTypes {
+		if !isValidDocType(docType) {
+			return errorJSON(errCodeInvalidArgs, "Unknown docType " + docType + " in docTypes. Expecting one of: " + strings.Join(validDocTypes, ", "))
+		}
+	}
+
+	configKey, err := stub.CreateCompositeKey(configObjectType, []string{})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.PutState(configKey, configAsBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(configAsBytes)
+}
+
+// ===============================================
+// getConfig - read the config in effect, defaults included
+// ===============================================
+func (t *SimpleChaincode) getConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	config, err := loadConfig(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(configAsBytes)
+}
+
+// loadConfig reads the stored config over defaultConfig, or returns defaultConfig if unset.
+func loadConfig(stub shim.ChaincodeStubInterface) (chaincodeConfig, error) {
+	config := defaultConfig()
+	configKey, err := stub.CreateCompositeKey(configObjectType, []string{})
+	if err != nil {
+		return config, err
+	}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return config, fmt.Errorf("failed to get config: %s", err)
+	} else if configAsBytes == nil {
+		return config, nil
+	}
+	err = json.Unmarshal(configAsBytes, &config)
+	if err != nil {
+		return config, fmt.Errorf("failed to decode config: %s", err)
+	}
+	return config, nil
+}
+
+// setEvent is the single place events are set. A payload larger than the configured
+// maxEventSize is replaced by {"key","docType","truncated":true}, so an oversized event
+// never fails the invoke; listeners can read the full record by key.
+func setEvent(stub shim.ChaincodeStubInterface, name string, key string, docType string, payload []byte) error {
+	config, err := loadConfig(stub)
+	if err != nil {
+		return err
+	}
+	if len(payload) > config.MaxEventSize {
+		fmt.Printf("- %s event for %s truncated: %d bytes exceeds %d\n", name, key, len(payload), config.MaxEventSize)
+		payload, err = json.Marshal(map[string]interface{}{"key": key, "docType": docType, "truncated": true})
+		if err != nil {
+			return err
+		}
+	}
+	return stub.SetEvent(name, payload)
+}
+
+// checkMaxDeposit fails when deposit exceeds the configured maxDeposit.
+func checkMaxDeposit(stub shim.ChaincodeStubInterface, deposit int) error {
+	config, err := loadConfig(stub)
+	if err != nil {
+		return err
+	}
+	if config.MaxDeposit > 0 && deposit > config.MaxDeposit {
+		return fmt.Errorf("deposit must be at most %d", config.MaxDeposit)
+	}
+	return nil
+}
+
+// Error codes carried by errorJSON responses
+const (
+	errCodeInvalidArgs      = "INVALID_ARGS"
+	errCodeNotFound         = "NOT_FOUND"
+	errCodeAlreadyExists    = "ALREADY_EXISTS"
+	errCodeReferenceMissing = "REFERENCE_MISSING"
+	errCodeInvalidState     = "INVALID_STATE"
+	errCodeUnauthorized     = "UNAUTHORIZED"
+	errCodeConflict         = "CONFLICT"
+	errCodeTooManyResults   = "TOO_MANY_RESULTS"
+	errCodeInternal         = "INTERNAL"
+)
+
+// tooManyResultsError is returned when a non-paginated query matches more than the
+// configured maxQueryResults records.
+type tooManyResultsError struct {
+	max int
+}
+
+func (e tooManyResultsError) Error() string {
+	return fmt.Sprintf("query matched more than %d records, use the paginated variant instead", e.max)
+}
+
+// queryErrorJSON is errorJSON for a failed query, reporting TOO_MANY_RESULTS when the
+// result cap was hit and INTERNAL otherwise.
+func queryErrorJSON(err error) pb.Response {
+	if _, ok := err.(tooManyResultsError); ok {
+		return errorJSON(errCodeTooManyResults, err.Error())
+	}
+	return errorJSON(errCodeInternal, err.Error())
+}
+
+// errorJSON returns a shim.Error whose message is the JSON body
+// {"error":{"code":...,"message":...}}, so clients can parse every failure the same way.
+func errorJSON(code string, message string) pb.Response {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	body.Error.Code = code
+	body.Error.Message = message
+	bodyAsBytes, _ := json.Marshal(body)
+	return shim.Error(string(bodyAsBytes))
+}
+
+// =========================================================================================
+// findConditionForProperty returns the key of a condition referencing propertyNum,
+// or "" if no condition does. It follows the "property~condition" index, which
+// createCondition and the condition deletes keep in the same transaction; entries
+// that no longer resolve are skipped.
+// =========================================================================================
+func findConditionForProperty(stub shim.ChaincodeStubInterface, propertyNum string) (string, error) {
+	conditionNums, err := indexEntries(stub, "property~condition", propertyNum)
+	if err != nil {
+		return "", err
+	}
+	for _, conditionNum := range conditionNums {
+		conditionAsBytes, err := stub.GetState(conditionNum)
+		if err != nil {
+			return "", err
+		}
+		linkedCondition := conditionOfContract{}
+		if conditionAsBytes != nil && json.Unmarshal(conditionAsBytes, &linkedCondition) == nil && linkedCondition.ObjectType == "condition" && linkedCondition.Property_num == propertyNum {
+			return conditionNum, nil
+		}
+	}
+	return "", nil
+}
+
+// requireAdmin fails unless the invoker's certificate carries role=admin.
+func requireAdmin(stub shim.ChaincodeStubInterface) error {
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("caller is not an admin")
+	}
+	return nil
+}
+
+// requireRole fails unless the invoker's role attribute is one of roles.
+func requireRole(stub shim.ChaincodeStubInterface, roles ...string) error {
+	role, found, err := cid.GetAttributeValue(stub, roleAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to read the %s attribute: %s", roleAttribute, err)
+	}
+	if found {
+		for _, allowed := range roles {
+			if role == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s attribute must be one of: %s", roleAttribute, strings.Join(roles, ", "))
+}
+
+// isAdmin reports whether the invoker's certificate carries role=admin.
+func isAdmin(stub shim.ChaincodeStubInterface) (bool, error) {
+	role, found, err := cid.GetAttributeValue(stub, roleAttribute)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the %s attribute: %s", roleAttribute, err)
+	}
+	return found && role == "admin", nil
+}
+
+// invokerName returns the lowercased enrollment ID of the invoker, which Fabric CA
+// puts in every certificate it issues. It is compared against owner_key and party names.
+func invokerName(stub shim.ChaincodeStubInterface) (string, error) {
+	enrollmentID, found, err := cid.GetAttributeValue(stub, "hf.EnrollmentID")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the invoker identity: %s", err)
+	}
+	if !found {
+		return "", fmt.Errorf("invoker certificate has no hf.EnrollmentID attribute")
+	}
+	return strings.ToLower(enrollmentID), nil
+}
+
+// invokerID identifies the invoker by MSP ID and the client ID cid derives from the
+// certificate's subject and issuer, so it holds even for certificates without attributes.
+func invokerID(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the invoker MSP ID: %s", err)
+	}
+	clientID, err := cid.GetID(stub)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the invoker identity: %s", err)
+	}
+	return mspID + "/" + clientID, nil
+}
+
+// requireOwnerOrAdmin fails unless the invoker is one of the owners identified by ownerKeys
+// or an admin. Enrollment IDs are only unique within one CA, so when ownerMSP is set the
+// invoker must also be enrolled with that MSP; "" matches by enrollment ID alone.
+func requireOwnerOrAdmin(stub shim.ChaincodeStubInterface, ownerMSP string, ownerKeys ...string) error {
+	admin, err := isAdmin(stub)
+	if err != nil {
+		return err
+	} else if admin {
+		return nil
+	}
+	invoker, err := invokerName(stub)
+	if err != nil {
+		return err
+	}
+	if ownerMSP != "" {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return fmt.Errorf("failed to read the invoker MSP ID: %s", err)
+		}
+		if mspID != ownerMSP {
+			return fmt.Errorf("%s of %s is not the owner; the owners are enrolled with %s", invoker, mspID, ownerMSP)
+		}
+	}
+	for _, ownerKey := range ownerKeys {
+		if invoker == ownerKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not the owner", invoker)
+}
+
+// ===========================================================================================
+// deleteAllByType - admin cleanup that deletes every record of one docType, e.g. to reset a
+// test environment. With dryRun set it only lists the keys that would be deleted. Properties
+// still referenced by a condition, and conditions still referenced by a contract, block the
+// delete so the record graph stays intact; private deposits and index entries go with their
+// records. Each invocation covers at most pageSize (default migrateBatchSize) records of the
+// docType, starting at the optional startKey, and returns {"deleted":n,"nextKey":"..."}.
+// Invoke again with nextKey until it comes back empty. Pagination APIs are read-only in
+// Fabric, so batches follow keys as in migrateRecords.
+// ===========================================================================================
+func (t *SimpleChaincode) deleteAllByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0           1       2 (optional startKey)   3 (optional pageSize)
+	// "condition", "true", "1200",                  "100"
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+
+	docType := strings.ToLower(args[0])
+	if err := checkDocType(stub, docType); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	dryRun, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "2nd argument must be true or false")
+	}
+	startKey := ""
+	if len(args) > 2 {
+		startKey = args[2]
+	}
+	pageSize := migrateBatchSize
+	if len(args) > 3 {
+		pageSize, err = parsePositiveInt("pageSize", args[3])
+		if err != nil {
+			return errorJSON(errCodeInvalidArgs, err.Error())
+		}
+	}
+	fmt.Println("- start deleteAllByType ", docType, dryRun, startKey)
+
+	resultsIterator, err := stub.GetStateByRange(startKey, "")
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	keys := []string{}
+	values := make(map[string][]byte)
+	nextKey := ""
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		var header struct {
+			ObjectType string `json:"docType"`
+		}
+		if json.Unmarshal(queryResponse.Value, &header) != nil || header.ObjectType != docType {
+			continue
+		}
+		if len(keys) == pageSize {
+			nextKey = queryResponse.Key
+			break
+		}
+		keys = append(keys, queryResponse.Key)
+		values[queryResponse.Key] = queryResponse.Value
+	}
+
+	// ==== Refuse the batch while any of its records is still referenced ====
+	var blockers []string
+	for _, key := range keys {
+		switch docType {
+		case "property":
+			conditionNum, err := findConditionForProperty(stub, key)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			} else if conditionNum != "" {
+				blockers = append(blockers, key + " (condition " + conditionNum + ")")
+			}
+		case "condition":
+			contractAsBytes, err := findContractForCondition(stub, key)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			} else if contractAsBytes != nil {
+				existing := contract{}
+				json.Unmarshal(contractAsBytes, &existing)
+				blockers = append(blockers, key + " (contract " + existing.Contract_num + ")")
+			}
+		}
+	}
+	if len(blockers) > 0 {
+		return errorJSON(errCodeInvalidState, "Records still referenced: " + strings.Join(blockers, ", "))
+	}
+
+	if dryRun {
+		keysAsBytes, err := json.Marshal(map[string]interface{}{"keys": keys, "nextKey": nextKey})
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		return shim.Success(keysAsBytes)
+	}
+
+	for _, key := range keys {
+		switch docType {
+		case "property":
+			record := property{}
+			json.Unmarshal(values[key], &record)
+			for _, ownerKey := range ownerKeysOf(record) {
+				err = delOwnerIndex(stub, ownerKey, key)
+				if err != nil {
+					return errorJSON(errCodeInternal, "Failed to delete owner index:" + err.Error())
+				}
+			}
+		case "condition":
+			record := conditionOfContract{}
+			json.Unmarshal(values[key], &record)
+			err = delIndexEntry(stub, "property~condition", record.Property_num, key)
+			if err != nil {
+				return errorJSON(errCodeInternal, "Failed to delete condition index:" + err.Error())
+			}
+			if record.DepositPrivate {
+				err = stub.DelPrivateData(depositCollection, key)
+				if err != nil {
+					return errorJSON(errCodeInternal, "Failed to delete private deposit:" + err.Error())
+				}
+			}
+		case "contract":
+			record := contract{}
+			json.Unmarshal(values[key], &record)
+			err = delIndexEntry(stub, "condition~contract", record.Condition_num, key)
+			if err != nil {
+				return errorJSON(errCodeInternal, "Failed to delete contract index:" + err.Error())
+			}
+			linkedCondition := conditionOfContract{}
+			if getRecord(stub, record.Condition_num, "condition", &linkedCondition) == nil {
+				err = delIndexEntry(stub, "property~activecontract", linkedCondition.Property_num, key)
+				if err != nil {
+					return errorJSON(errCodeInternal, "Failed to delete contract index:" + err.Error())
+				}
+			}
+		}
+		err = stub.DelState(key)
+		if err != nil {
+			return errorJSON(errCodeInternal, "Failed to delete state:" + err.Error())
+		}
+	}
+
+	fmt.Printf("- end deleteAllByType: %d deleted\n", len(keys))
+	return shim.Success([]byte(fmt.Sprintf("{\"deleted\":%d,\"nextKey\":\"%s\"}", len(keys), nextKey)))
+}
+
+// txTimestamp returns the transaction timestamp formatted as RFC3339. Unlike time.Now it
+// is identical on every endorser, which keeps the written state deterministic.
+func txTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC().Format(time.RFC3339), nil
+}
+
+// ===========================================================================================
+// getRecordCountByType - counts the records of one docType without shipping them to the
+// client, by following pagination bookmarks and summing FetchedRecordsCount.
+// Rich queries need CouchDB. On LevelDB the query fails, and properties are counted from the
+// "owner~propertynum" composite key index instead; conditions and contracts have no index
+// to fall back on.
+// ===========================================================================================
+func (t *SimpleChaincode) getRecordCountByType(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "property"
+	docType := strings.ToLower(args[0])
+	if err := checkDocType(stub, docType); err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+
+	count, err := countByRichQuery(stub, docType)
+	if err != nil && docType == "property" {
+		count, err = countOwnerIndex(stub)
+	}
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	return shim.Success([]byte(strconv.Itoa(count)))
+}
+
+// ===========================================================================================
+// getOrphanedRecords - admin diagnostic listing conditions whose property and contracts whose
+// condition no longer resolve. It pages through the whole key space and reads every
+// referenced record, so it is expensive on large ledgers; run it as a query, not an invoke.
+// ===========================================================================================
+func (t *SimpleChaincode) getOrphanedRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	fmt.Println("- start getOrphanedRecords")
+
+	report := orphanReport{OrphanedConditions: []string{}, OrphanedContracts: []string{}}
+	bookmark := ""
+	for {
+		resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination("", "", countPageSize, bookmark)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return errorJSON(errCodeInternal, err.Error())
+			}
+
+			var record struct {
+				ObjectType    string `json:"docType"`
+				Property_num  string `json:"property_num"`
+				Condition_num string `json:"condition_num"`
+			}
+			if json.Unmarshal(queryResponse.Value, &record) != nil {
+				continue // not one of our JSON records
+			}
+			switch record.ObjectType {
+			case "condition":
+				resolved, err := recordExists(stub, record.Property_num, "property")
+				if err != nil {
+					resultsIterator.Close()
+					return errorJSON(errCodeInternal, err.Error())
+				} else if !resolved {
+					report.OrphanedConditions = append(report.OrphanedConditions, queryResponse.Key)
+				}
+			case "contract":
+				resolved, err := recordExists(stub, record.Condition_num, "condition")
+				if err != nil {
+					resultsIterator.Close()
+					return errorJSON(errCodeInternal, err.Error())
+				} else if !resolved {
+					report.OrphanedContracts = append(report.OrphanedContracts, queryResponse.Key)
+				}
+			}
+		}
+		resultsIterator.Close()
+
+		if responseMetadata.FetchedRecordsCount < countPageSize || responseMetadata.Bookmark == "" || responseMetadata.Bookmark == bookmark {
+			break
+		}
+		bookmark = responseMetadata.Bookmark
+	}
+
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Printf("- end getOrphanedRecords: %d conditions, %d contracts\n", len(report.OrphanedConditions), len(report.OrphanedContracts))
+	return shim.Success(reportAsBytes)
+}
+
+// recordExists reports whether key holds a record of docType.
+func recordExists(stub shim.ChaincodeStubInterface, key string, docType string) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+	valAsbytes, err := stub.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to get %s %s: %s", docType, key, err)
+	}
+	var header struct {
+		ObjectType string `json:"docType"`
+	}
+	return valAsbytes != nil && json.Unmarshal(valAsbytes, &header) == nil && header.ObjectType == docType, nil
+}
+
+// countByRichQuery pages through {"docType":docType} and sums the fetched record counts.
+func countByRichQuery(stub shim.ChaincodeStubInterface, docType string) (int, error) {
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"%s\"},\"fields\":[\"docType\"]}", docType)
+
+	count := 0
+	bookmark := ""
+	for {
+		resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(queryString, countPageSize, bookmark)
+		if err != nil {
+			return 0, err
+		}
+		resultsIterator.Close()
+
+		count += int(responseMetadata.FetchedRecordsCount)
+		if responseMetadata.FetchedRecordsCount < countPageSize || responseMetadata.Bookmark == "" || responseMetadata.Bookmark == bookmark {
+			return count, nil
+		}
+		bookmark = responseMetadata.Bookmark
+	}
+}
+
+// ===========================================================================================
+// getByPartialCompositeKey - admin debugging aid listing the composite keys of an index
+// namespace, e.g. "owner~propertynum" or "idempotency~key", optionally narrowed by leading
+// attributes. Each key is returned split into its object type and attributes.
+// ===========================================================================================
+func (t *SimpleChaincode) getByPartialCompositeKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0                     1...
+	// "owner~propertynum", "tom"
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if len(args[0]) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a non-empty string")
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(args[0], args[1:])
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	keys := []compositeKeyEntry{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		objectType, attributes, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		keys = append(keys, compositeKeyEntry{objectType, attributes})
+	}
+
+	keysAsBytes, err := json.Marshal(keys)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(keysAsBytes)
+}
+
+// ===========================================================================================
+// reindexOwners - admin repair that brings the "owner~propertynum" index back in line with the
+// property records, e.g. dropping dangling entries left by earlier versions. It runs in two
+// phases: "prune" deletes every entry whose property is gone or no longer lists that owner,
+// then "rebuild" writes any missing entry for every owner of every property. Each invocation
+// covers at most pageSize (default migrateBatchSize) entries or properties and returns
+// {"deleted":n,"created":m,"nextKey":"..."}; invoke again with nextKey, which records the
+// phase, until it comes back empty. The index stays valid between invocations.
+// Pagination APIs are read-only in Fabric and range reads cannot start inside composite
+// keys, so the prune phase skips the entries before nextKey instead of seeking to it.
+// ===========================================================================================
+func (t *SimpleChaincode) reindexOwners(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0 (optional nextKey)   1 (optional pageSize)
+	// "rebuild:1200",          "100"
+	err := requireAdmin(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	phase, startKey := "prune", ""
+	if len(args) > 0 && len(args[0]) > 0 {
+		separator := strings.Index(args[0], ":")
+		if separator < 0 || (args[0][:separator] != "prune" && args[0][:separator] != "rebuild") {
+			return errorJSON(errCodeInvalidArgs, "1st argument must be a nextKey returned by reindexOwners")
+		}
+		phase, startKey = args[0][:separator], args[0][separator+1:]
+	}
+	pageSize := migrateBatchSize
+	if len(args) > 1 {
+		pageSize, err = parsePositiveInt("pageSize", args[1])
+		if err != nil {
+			return errorJSON(errCodeInvalidArgs, err.Error())
+		}
+	}
+	fmt.Println("- start reindexOwners ", phase, startKey)
+
+	deleted, created, scanned := 0, 0, 0
+	nextKey := ""
+	if phase == "prune" {
+		indexIterator, err := stub.GetStateByPartialCompositeKey("owner~propertynum", []string{})
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		defer indexIterator.Close()
+
+		nextKey = "rebuild:"
+		for indexIterator.HasNext() {
+			responseRange, err := indexIterator.Next()
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			if responseRange.Key < startKey {
+				continue
+			}
+			if scanned == pageSize {
+				nextKey = "prune:" + responseRange.Key
+				break
+			}
+			scanned++
+
+			_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			indexedProperty := property{}
+			if getRecord(stub, compositeKeyParts[1], "property", &indexedProperty) == nil {
+				listed := false
+				for _, ownerKey := range ownerKeysOf(indexedProperty) {
+					listed = listed || ownerKey == compositeKeyParts[0]
+				}
+				if listed {
+					continue
+				}
+			}
+			err = stub.DelState(responseRange.Key)
+			if err != nil {
+				return errorJSON(errCodeInternal, "Failed to delete owner index:" + err.Error())
+			}
+			deleted++
+		}
+	} else {
+		resultsIterator, err := stub.GetStateByRange(startKey, "")
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		defer resultsIterator.Close()
+
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				return errorJSON(errCodeInternal, err.Error())
+			}
+			var record property
+			if json.Unmarshal(queryResponse.Value, &record) != nil || record.ObjectType != "property" {
+				continue
+			}
+			if scanned == pageSize {
+				nextKey = "rebuild:" + queryResponse.Key
+				break
+			}
+			scanned++
+
+			for _, ownerKey := range ownerKeysOf(record) {
+				ownerPropertyIndexKey, err := stub.CreateCompositeKey("owner~propertynum", []string{ownerKey, queryResponse.Key})
+				if err != nil {
+					return errorJSON(errCodeInternal, err.Error())
+				}
+				entryAsBytes, err := stub.GetState(ownerPropertyIndexKey)
+				if err != nil {
+					return errorJSON(errCodeInternal, err.Error())
+				} else if entryAsBytes != nil {
+					continue
+				}
+				err = putOwnerIndex(stub, ownerKey, queryResponse.Key)
+				if err != nil {
+					return errorJSON(errCodeInternal, err.Error())
+				}
+				created++
+			}
+		}
+	}
+
+	resultAsBytes, err := json.Marshal(map[string]interface{}{"deleted": deleted, "created": created, "nextKey": nextKey})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	fmt.Printf("- end reindexOwners: %d deleted, %d created\n", deleted, created)
+	return shim.Success(resultAsBytes)
+}
+
+// countOwnerIndex counts the distinct properties in the "owner~propertynum" index. A
+// co-owned property has one entry per owner, so entries are counted by property_num.
+func countOwnerIndex(stub shim.ChaincodeStubInterface) (int, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey("owner~propertynum", []string{})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	propertyNums := make(map[string]bool)
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return 0, err
+		}
+		propertyNums[compositeKeyParts[1]] = true
+	}
+	return len(propertyNums), nil
+}
+
+// ===========================================================
+// markDepositPaid - the buyer of a condition, identified by enrollment ID, confirms the
+// deposit has been posted. completeContract refuses to move the property until then.
+// ===========================================================
+func (t *SimpleChaincode) markDepositPaid(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+
+	conditionNum := args[0]
+	fmt.Println("- start markDepositPaid ", conditionNum)
+
+	conditionToMark := conditionOfContract{}
+	err := getRecord(stub, conditionNum, "condition", &conditionToMark)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	invoker, err := invokerName(stub)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if invoker != conditionToMark.Buyer {
+		return errorJSON(errCodeUnauthorized, invoker + " is not the buyer of condition " + conditionNum)
+	}
+	if conditionToMark.DepositPaid {
+		return errorJSON(errCodeAlreadyExists, "Deposit of condition " + conditionNum + " is already marked paid")
+	}
+
+	conditionToMark.DepositPaid = true
+	conditionToMark.UpdatedAt, err = txTimestamp(stub)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	conditionJSONasBytes, err := json.Marshal(conditionToMark)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.PutState(conditionNum, conditionJSONasBytes) //rewrite the condition
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end markDepositPaid (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// setKeyEndorsementPolicy - require a peer of every named organization (MSP ID) to endorse
+// any further change to a property, e.g. a high-value listing shared by two agencies. This
+// replaces the key's current policy. Only an owner or an admin may set it.
+// ===========================================================
+func (t *SimpleChaincode) setKeyEndorsementPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0      1         2...
+	// "100", "Org1MSP", "Org2MSP"
+	propertyNum := args[0]
+	orgs := args[1:]
+
+	propertyToGuard := property{}
+	err := getRecord(stub, propertyNum, "property", &propertyToGuard)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	err = requireOwnerOrAdmin(stub, propertyToGuard.OwnerMSP, ownerKeysOf(propertyToGuard)...)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	for _, org := range orgs {
+		if len(org) <= 0 {
+			return errorJSON(errCodeInvalidArgs, "organization MSP IDs must be non-empty strings")
+		}
+	}
+
+	endorsementPolicy, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = endorsementPolicy.AddOrgs(statebased.RoleTypePeer, orgs...)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, err.Error())
+	}
+	policyAsBytes, err := endorsementPolicy.Policy()
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.SetStateValidationParameter(propertyNum, policyAsBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// getKeyEndorsementPolicy - list the organizations whose endorsement a key requires.
+// An empty list means the key only follows the chaincode endorsement policy.
+// ===========================================================
+func (t *SimpleChaincode) getKeyEndorsementPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "100"
+	key := args[0]
+	result := keyEndorsementPolicy{Key: key, Orgs: []string{}}
+
+	policyAsBytes, err := stub.GetStateValidationParameter(key)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	if len(policyAsBytes) > 0 {
+		endorsementPolicy, err := statebased.NewStateEP(policyAsBytes)
+		if err != nil {
+			return errorJSON(errCodeInternal, err.Error())
+		}
+		result.Orgs = endorsementPolicy.ListOrgs()
+		sort.Strings(result.Orgs)
+	}
+
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	return shim.Success(resultAsBytes)
+}
+
+// ===========================================================
+// completeContract - finalize a signed contract: the seller's share of the property (the
+// whole property unless it is co-owned) passes to the condition's buyer and the contract
+// becomes "completed". Both writes and the "DealCompleted" event
+// belong to this one transaction, so the deal can never be left half done.
+// The buyer must have marked the condition's deposit paid first (markDepositPaid).
+// Only the seller or an admin may complete the deal.
+// ===========================================================
+func (t *SimpleChaincode) completeContract(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "1"
+	contractNum := args[0]
+	fmt.Println("- start completeContract ", contractNum)
+
+	chain, err := resolveContractChain(stub, contractNum)
+	if err != nil {
+		return errorJSON(errCodeNotFound, err.Error())
+	}
+	err = requireOwnerOrAdmin(stub, chain.Property.OwnerMSP, chain.Condition.Seller)
+	if err != nil {
+		return errorJSON(errCodeUnauthorized, err.Error())
+	}
+	if chain.Contract.Status != "signed" {
+		return errorJSON(errCodeInvalidState, "Contract " + contractNum + " cannot be completed from status \"" + chain.Contract.Status + "\"")
+	}
+	if !chain.Condition.DepositPaid {
+		return errorJSON(errCodeInvalidState, "Contract " + contractNum + " cannot be completed before the buyer marks the deposit of condition " + chain.Condition.Condition_num + " paid")
+	}
+
+	// ==== Transfer the seller's share of the property to the buyer ====
+	err = setPropertyOwners(stub, &chain.Property, replaceOwner(chain.Property, chain.Condition.Seller, chain.Condition.Buyer))
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	// ==== Mark the contract completed ====
+	chain.Contract.Status = "completed"
+	chain.Contract.UpdatedAt = chain.Property.UpdatedAt
+	err = delIndexEntry(stub, "property~activecontract", chain.Condition.Property_num, contractNum)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	contractJSONasBytes, err := json.Marshal(chain.Contract)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = stub.PutState(contractNum, contractJSONasBytes) //rewrite the contract
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	chainAsBytes, err := json.Marshal(chain)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+	err = setEvent(stub, "DealCompleted", contractNum, "contract", chainAsBytes)
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	fmt.Println("- end completeContract (success)")
+	return shim.Success(nil)
+}
+
+// =========================================================================================
+// findActiveContractForProperty returns the number of a pending or signed contract created
+// from any condition on propertyNum, or "" if the property is not part of an active deal.
+// It follows the "property~activecontract" index: CreateContract adds an entry, and
+// cancelContract and completeContract remove it when the deal ends.
+// =========================================================================================
+func findActiveContractForProperty(stub shim.ChaincodeStubInterface, propertyNum string) (string, error) {
+	contractNums, err := indexEntries(stub, "property~activecontract", propertyNum)
+	if err != nil {
+		return "", err
+	}
+	for _, contractNum := range contractNums {
+		contractAsBytes, err := stub.GetState(contractNum)
+		if err != nil {
+			return "", err
+		}
+		linkedContract := contract{}
+		if contractAsBytes == nil || json.Unmarshal(contractAsBytes, &linkedContract) != nil || linkedContract.ObjectType != "contract" {
+			continue // dangling index entry
+		}
+		if linkedContract.Status == "pending" || linkedContract.Status == "signed" {
+			return linkedContract.Contract_num, nil
+		}
+	}
+	return "", nil
+}
+
+// ===== Example: Parameterized rich query =================================================
+// getPropertiesByAddressPrefix queries for properties whose address starts with a prefix,
+// e.g. a neighborhood. The prefix is normalized the way initProperty stores addresses.
+// Only available on state databases that support rich query (e.g. CouchDB)
+//
+// $regex cannot use an index to narrow the scan: CouchDB still reads every document the rest
+// of the selector matches. An index on docType keeps that to properties only, e.g. with Fauxton:
+// {"index":{"fields":["docType","address"]},"ddoc":"indexAddressDoc", "name":"indexAddress","type":"json"}
+// =========================================================================================
+func (t *SimpleChaincode) getPropertiesByAddressPrefix(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "seoul gangnam"
+	prefix := strings.ToLower(strings.Join(strings.Fields(args[0]), " "))
+	if len(prefix) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "1st argument must not be blank")
+	}
+
+	// quote regex metacharacters, then let json.Marshal escape the pattern for the selector
+	patternAsBytes, _ := json.Marshal("^" + regexp.QuoteMeta(prefix))
+	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"property\",\"address\":{\"$regex\":%s}}}", patternAsBytes)
+
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ===== Example: Parameterized rich query =================================================
+// searchProperties combines the owner and address-prefix searches, e.g.
+// {"owner":"tom","addressPrefix":"baker street"}. Only the constraints given end up in the
+// selector; at least one is required so a search never dumps every property.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (t *SimpleChaincode) searchProperties(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	//   0
+	// "{\"owner\":\"tom\",\"addressPrefix\":\"baker street\"}"
+	var search struct {
+		Owner         string `json:"owner"`
+		AddressPrefix string `json:"addressPrefix"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&search)
+	if err != nil {
+		return errorJSON(errCodeInvalidArgs, "1st argument must be a JSON object with owner and/or addressPrefix: " + err.Error())
+	}
+
+	// normalize the same way initProperty stores owners and addresses
+	ownerKey := strings.ToLower(strings.TrimSpace(search.Owner))
+	prefix := strings.ToLower(strings.Join(strings.Fields(search.AddressPrefix), " "))
+	if len(ownerKey) <= 0 && len(prefix) <= 0 {
+		return errorJSON(errCodeInvalidArgs, "at least one of owner and addressPrefix is required")
+	}
+
+	selector := map[string]interface{}{"docType": "property"}
+	if len(ownerKey) > 0 {
+		selector["$or"] = []interface{}{
+			map[string]interface{}{"owner_key": ownerKey},
+			map[string]interface{}{"owners": map[string]interface{}{"$elemMatch": map[string]interface{}{"$eq": ownerKey}}},
+		}
+	}
+	if len(prefix) > 0 {
+		selector["address"] = map[string]interface{}{"$regex": "^" + regexp.QuoteMeta(prefix)}
+	}
+	queryAsBytes, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return errorJSON(errCodeInternal, err.Error())
+	}
+
+	queryResults, err := getQueryResultForQueryString(stub, string(queryAsBytes))
+	if err != nil {
+		return queryErrorJSON(err)
+	}
+	return shim.Success(queryResults)
 }
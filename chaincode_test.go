@@ -0,0 +1,1767 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testStub is a MockStub driven the way a peer drives the chaincode: every invoke is a
+// transaction of its own, made by the identity passed to it. MockStub has no query engine,
+// so with richQuery set GetQueryResult answers CouchDB selectors over the mock state;
+// without it rich queries fail the way they do on LevelDB.
+type testStub struct {
+	*shim.MockStub
+	args      [][]byte
+	richQuery bool
+	txCount   int
+	transient map[string][]byte
+}
+
+func newTestStub(richQuery bool) *testStub {
+	return &testStub{MockStub: shim.NewMockStub("chaincode", new(SimpleChaincode)), richQuery: richQuery}
+}
+
+// invoke runs function with args as one transaction made by identity.
+func (stub *testStub) invoke(identity []byte, function string, args ...string) pb.Response {
+	// transaction IDs are hex SHA-256 digests on a peer, so derive them the same way
+	stub.txCount++
+	txHash := sha256.Sum256([]byte(strconv.Itoa(stub.txCount)))
+	txID := hex.EncodeToString(txHash[:])
+	stub.args = [][]byte{[]byte(function)}
+	for _, arg := range args {
+		stub.args = append(stub.args, []byte(arg))
+	}
+	stub.Creator = identity
+	stub.MockTransactionStart(txID)
+	response := new(SimpleChaincode).Invoke(stub)
+	stub.MockTransactionEnd(txID)
+
+	// MockStub buffers events in a bounded channel; drop them so long tests never block
+	for {
+		select {
+		case <-stub.ChaincodeEventsChannel:
+			continue
+		default:
+		}
+		break
+	}
+	return response
+}
+
+func (stub *testStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *testStub) GetStringArgs() []string {
+	var args []string
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *testStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+// GetTransient returns the transient map set on the stub; MockStub always returns nil.
+func (stub *testStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+// DelPrivateData deletes from the mock's private state, which MockStub does not implement.
+func (stub *testStub) DelPrivateData(collection string, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+// GetPrivateDataHash returns the SHA-256 of the mock's private state, as the peer does;
+// MockStub does not implement it.
+func (stub *testStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+	value, found := stub.PvtState[collection][key]
+	if !found {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+// GetStateByRange treats an empty startKey or endKey as open and skips composite keys, as
+// the peer does; MockStub returns nothing for a startKey without an endKey.
+func (stub *testStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = "\x01"
+	}
+	if endKey == "" {
+		endKey = string(utf8.MaxRune)
+	}
+	return stub.MockStub.GetStateByRange(startKey, endKey)
+}
+
+// GetStateByRangeWithPagination pages like the peer: the bookmark is the key the next page
+// starts at, "" once the range is exhausted. MockStub does not implement it.
+func (stub *testStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	iterator, err := stub.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iterator.Close()
+
+	page := &sliceIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if int32(len(page.kvs)) == pageSize {
+			metadata.Bookmark = kv.Key
+			break
+		}
+		page.kvs = append(page.kvs, kv)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.kvs))
+	return page, metadata, nil
+}
+
+// GetQueryResult supports the selector operators the chaincode uses, over every JSON record
+// in key order. Composite keys are index entries, not documents, and are skipped.
+func (stub *testStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	if !stub.richQuery {
+		return stub.MockStub.GetQueryResult(query)
+	}
+	var parsed struct {
+		Selector map[string]interface{} `json:"selector"`
+	}
+	err := json.Unmarshal([]byte(query), &parsed)
+	if err != nil {
+		return nil, err
+	}
+	results := &sliceIterator{}
+	for elem := stub.Keys.Front(); elem != nil; elem = elem.Next() {
+		key := elem.Value.(string)
+		if strings.HasPrefix(key, "\x00") {
+			continue
+		}
+		var doc map[string]interface{}
+		if json.Unmarshal(stub.State[key], &doc) != nil {
+			continue
+		}
+		if matchesSelector(doc, parsed.Selector) {
+			results.kvs = append(results.kvs, &queryresult.KV{Key: key, Value: stub.State[key]})
+		}
+	}
+	return results, nil
+}
+
+// sliceIterator iterates over the results of testStub.GetQueryResult
+type sliceIterator struct {
+	kvs  []*queryresult.KV
+	next int
+}
+
+func (iter *sliceIterator) HasNext() bool {
+	return iter.next < len(iter.kvs)
+}
+
+func (iter *sliceIterator) Next() (*queryresult.KV, error) {
+	if !iter.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	iter.next++
+	return iter.kvs[iter.next-1], nil
+}
+
+func (iter *sliceIterator) Close() error {
+	return nil
+}
+
+func matchesSelector(doc map[string]interface{}, selector map[string]interface{}) bool {
+	for field, condition := range selector {
+		if field == "$or" {
+			matched := false
+			for _, alternative := range condition.([]interface{}) {
+				matched = matched || matchesSelector(doc, alternative.(map[string]interface{}))
+			}
+			if !matched {
+				return false
+			}
+		} else if !matchesCondition(doc[field], condition) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesCondition(value interface{}, condition interface{}) bool {
+	operators, ok := condition.(map[string]interface{})
+	if !ok {
+		return reflect.DeepEqual(value, condition)
+	}
+	for operator, operand := range operators {
+		switch operator {
+		case "$eq":
+			if !reflect.DeepEqual(value, operand) {
+				return false
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			number, ok := value.(float64)
+			if !ok {
+				return false
+			}
+			limit := operand.(float64)
+			if (operator == "$gt" && number <= limit) || (operator == "$gte" && number < limit) ||
+				(operator == "$lt" && number >= limit) || (operator == "$lte" && number > limit) {
+				return false
+			}
+		case "$regex":
+			text, ok := value.(string)
+			if !ok || !regexp.MustCompile(operand.(string)).MatchString(text) {
+				return false
+			}
+		case "$elemMatch":
+			elems, _ := value.([]interface{})
+			matched := false
+			for _, elem := range elems {
+				matched = matched || matchesCondition(elem, operand)
+			}
+			if !matched {
+				return false
+			}
+		default:
+			panic("unsupported selector operator " + operator)
+		}
+	}
+	return true
+}
+
+// identity returns a serialized creator as Fabric CA would issue it to enrollmentID: an
+// X.509 certificate carrying the hf.EnrollmentID and, unless empty, the role attribute.
+func identity(t *testing.T, enrollmentID string, role string) []byte {
+	return identityInMSP(t, "Org1MSP", enrollmentID, role)
+}
+
+// identityInMSP is identity for a CA of the organization mspID.
+func identityInMSP(t *testing.T, mspID string, enrollmentID string, role string) []byte {
+	attrs := map[string]string{"hf.EnrollmentID": enrollmentID}
+	if role != "" {
+		attrs[roleAttribute] = role
+	}
+	attrsAsBytes, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: enrollmentID, Organization: []string{strings.ToLower(strings.TrimSuffix(mspID, "MSP"))}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrsAsBytes},
+		},
+	}
+	certAsBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certAsBytes})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+func checkOK(t *testing.T, response pb.Response, what string) {
+	t.Helper()
+	if response.Status != shim.OK {
+		t.Fatalf("%s failed: %s", what, response.Message)
+	}
+}
+
+// checkErrorCode checks response failed with the errorJSON code
+func checkErrorCode(t *testing.T, response pb.Response, code string, what string) {
+	t.Helper()
+	if response.Status == shim.OK {
+		t.Fatalf("%s succeeded, expected %s", what, code)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(response.Message), &body); err != nil || body.Error.Code != code {
+		t.Fatalf("%s failed with %s, expected %s", what, response.Message, code)
+	}
+}
+
+func readTestProperty(t *testing.T, stub *testStub, propertyNum string) property {
+	t.Helper()
+	record := property{}
+	if err := json.Unmarshal(stub.State[propertyNum], &record); err != nil {
+		t.Fatalf("property %s: %s", propertyNum, err)
+	}
+	return record
+}
+
+// resultKeys returns the keys of a {"Key","Record"} query result, failing unless it is an array
+func resultKeys(t *testing.T, response pb.Response, what string) []string {
+	t.Helper()
+	checkOK(t, response, what)
+	var results []struct {
+		Key string `json:"Key"`
+	}
+	if err := json.Unmarshal(response.Payload, &results); err != nil || results == nil {
+		t.Fatalf("%s returned %q, expected a JSON array", what, response.Payload)
+	}
+	keys := []string{}
+	for _, result := range results {
+		keys = append(keys, result.Key)
+	}
+	return keys
+}
+
+func TestTransferPropertyReplaysTransferId(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	spike := identity(t, "spike", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	checkOK(t, stub.invoke(tom, "transferProperty", "100", "jerry", "tx-42"), "transferProperty")
+	transferred := string(stub.State["100"])
+
+	// the retry of a timed-out submit gets the prior result and writes nothing
+	checkOK(t, stub.invoke(tom, "transferProperty", "100", "jerry", "tx-42"), "replayed transferProperty")
+	if string(stub.State["100"]) != transferred {
+		t.Fatalf("replay rewrote the property: %s", stub.State["100"])
+	}
+	checkErrorCode(t, stub.invoke(tom, "transferProperty", "100", "spike", "tx-42"), errCodeInvalidArgs, "transferId reused for another transfer")
+	checkErrorCode(t, stub.invoke(spike, "transferProperty", "100", "jerry", "tx-42"), errCodeUnauthorized, "transferId replayed by another invoker")
+	if owner := readTestProperty(t, stub, "100").Owner; owner != "jerry" {
+		t.Fatalf("owner is %s, expected jerry", owner)
+	}
+}
+
+func TestQueryFormatNDJSON(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "villa", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "102", "apartment", "seoul jongno 3", "jerry"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+
+	queries := [][]string{
+		{"getPropertiesByRange", "1", "999"},
+		{"getPropertiesByRange", "1", "999", "name"},
+		{"getPropertiesByOwnerIndexed", "tom"},
+		{"queryPropertiesByOwner", "tom"},
+		{"queryProperties", `{"selector":{"docType":"property"}}`},
+		{"getAllRecordsByType", "condition"},
+		{"getConditionsBySeller", "tom"},
+		{"getPropertiesByAddressPrefix", "seoul"},
+		{"searchProperties", `{"owner":"tom"}`},
+		{"queryContractsByStatus", "pending"},
+	}
+	for _, query := range queries {
+		response := stub.invoke(agent, query[0], query[1:]...)
+		checkOK(t, response, fmt.Sprint(query))
+		var expected []json.RawMessage
+		if err := json.Unmarshal(response.Payload, &expected); err != nil {
+			t.Fatal(err)
+		}
+
+		response = stub.invoke(agent, query[0], append(query[1:], `{"format":"ndjson"}`)...)
+		checkOK(t, response, fmt.Sprint(query, " as ndjson"))
+		lines := strings.SplitAfter(string(response.Payload), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			t.Fatalf("%v: ndjson does not end in a newline: %q", query, last)
+		}
+		lines = lines[:len(lines)-1]
+		if len(lines) != len(expected) {
+			t.Fatalf("%v: ndjson has %d lines, the array %d members", query, len(lines), len(expected))
+		}
+		for i, line := range lines {
+			var record, member interface{}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("%v: line %d does not decode: %s", query, i, err)
+			}
+			json.Unmarshal(expected[i], &member)
+			if !reflect.DeepEqual(record, member) {
+				t.Fatalf("%v: line %d is %s, expected %s", query, i, line, expected[i])
+			}
+		}
+	}
+
+	checkOK(t, stub.invoke(agent, "getAllRecordsByType", "property", `{"format":"json"}`), "explicit json format")
+	checkErrorCode(t, stub.invoke(agent, "getAllRecordsByType", "property", `{"format":"csv"}`), errCodeInvalidArgs, "unknown format")
+	checkErrorCode(t, stub.invoke(agent, "getConditionsBySeller", "tom", "ndjson"), errCodeInvalidArgs, "format without the option object")
+}
+
+func TestInitConditonRoundTrips(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	response := stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW")
+	checkOK(t, response, "initConditon")
+	if string(response.Payload) != "1" {
+		t.Fatalf("initConditon returned %q, expected the key 1", response.Payload)
+	}
+
+	conditionAsBytes := stub.State["1"]
+	condition := conditionOfContract{}
+	if err := json.Unmarshal(conditionAsBytes, &condition); err != nil {
+		t.Fatal(err)
+	}
+	if condition.ObjectType != "condition" || condition.Condition_num != "1" || condition.Property_num != "100" ||
+		condition.Seller != "tom" || condition.Buyer != "jerry" || condition.Deposit != 5000 || condition.Currency != "KRW" {
+		t.Fatalf("unexpected condition %+v", condition)
+	}
+	remarshalled, _ := json.Marshal(condition)
+	if string(remarshalled) != string(conditionAsBytes) {
+		t.Fatalf("condition does not round-trip:\n%s\n%s", conditionAsBytes, remarshalled)
+	}
+}
+
+func TestCreateContractRoundTrips(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+
+	checkErrorCode(t, stub.invoke(tom, "CreateContract", "two", "1"), errCodeInvalidArgs, "CreateContract with a non-numeric contract number")
+	checkErrorCode(t, stub.invoke(tom, "CreateContract", "2", "one"), errCodeInvalidArgs, "CreateContract with a non-numeric condition number")
+	response := stub.invoke(tom, "CreateContract", "2", "1")
+	checkOK(t, response, "CreateContract")
+	if string(response.Payload) != "2" {
+		t.Fatalf("CreateContract returned %q, expected the key 2", response.Payload)
+	}
+
+	response = stub.invoke(tom, "readValue", "2")
+	checkOK(t, response, "readValue")
+	stored := contract{}
+	if err := json.Unmarshal(response.Payload, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.ObjectType != "contract" || stored.Contract_num != "2" || stored.Condition_num != "1" || stored.Status != "pending" {
+		t.Fatalf("unexpected contract %+v", stored)
+	}
+	// only contract fields are stored, none of the condition's
+	var fields map[string]interface{}
+	json.Unmarshal(response.Payload, &fields)
+	for _, conditionField := range []string{"seller", "buyer", "deposit", "property_num"} {
+		if _, found := fields[conditionField]; found {
+			t.Fatalf("contract carries the condition field %s: %s", conditionField, response.Payload)
+		}
+	}
+}
+
+func TestTransferPropertyChangesOnlyOwner(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	before := readTestProperty(t, stub, "100")
+
+	checkOK(t, stub.invoke(tom, "transferProperty", "100", "jerry"), "transferProperty")
+
+	after := readTestProperty(t, stub, "100")
+	if after.Owner != "jerry" || after.OwnerKey != "jerry" {
+		t.Fatalf("owner is %s (%s), expected jerry", after.Owner, after.OwnerKey)
+	}
+	if after.Name != before.Name || after.Address != before.Address || after.Property_num != before.Property_num {
+		t.Fatalf("transfer changed more than the owner: %+v -> %+v", before, after)
+	}
+}
+
+func TestDeleteProperty(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "Jerry,Tom"), "initProperty")
+
+	checkErrorCode(t, stub.invoke(jerry, "deleteProperty", "100"), errCodeUnauthorized, "delete by a non-owner")
+	checkErrorCode(t, stub.invoke(tom, "deleteProperty", "101"), errCodeUnauthorized, "delete of a co-owned property by one co-owner")
+
+	// a condition blocks the delete even without a rich query engine
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkErrorCode(t, stub.invoke(tom, "deleteProperty", "100"), errCodeInvalidState, "delete of a referenced property")
+
+	checkOK(t, stub.invoke(tom, "deleteCondition", "1"), "deleteCondition")
+	checkOK(t, stub.invoke(tom, "deleteProperty", "100"), "delete by the owner")
+	checkOK(t, stub.invoke(admin, "deleteProperty", "101"), "delete of a co-owned property by an admin")
+}
+
+func TestInitConditonDeposit(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	for _, deposit := range []string{"0", "-5000"} {
+		response := stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", deposit, "KRW")
+		checkErrorCode(t, response, errCodeInvalidArgs, "initConditon with deposit "+deposit)
+		if !strings.Contains(response.Message, "deposit must be a positive number") {
+			t.Fatalf("unexpected message %s", response.Message)
+		}
+	}
+	if stub.State["1"] != nil {
+		t.Fatal("a rejected condition was stored")
+	}
+
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", " 5000 ", "KRW"), "initConditon with a padded deposit")
+	condition := conditionOfContract{}
+	json.Unmarshal(stub.State["1"], &condition)
+	if condition.Deposit != 5000 {
+		t.Fatalf("deposit is %d, expected 5000", condition.Deposit)
+	}
+}
+
+// contractInStatus sets up contract 2 on condition 1, tom selling property 100 to jerry,
+// and moves it to status.
+func contractInStatus(t *testing.T, status string) *testStub {
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+
+	stub := newTestStub(true)
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "CreateContract", "2", "1"), "CreateContract")
+	switch status {
+	case "signed":
+		checkOK(t, stub.invoke(admin, "signContract", "2"), "signContract")
+	case "completed":
+		checkOK(t, stub.invoke(admin, "signContract", "2"), "signContract")
+		checkOK(t, stub.invoke(jerry, "markDepositPaid", "1"), "markDepositPaid")
+		checkOK(t, stub.invoke(tom, "completeContract", "2"), "completeContract")
+	case "cancelled":
+		checkOK(t, stub.invoke(tom, "cancelContract", "2"), "cancelContract")
+	}
+	return stub
+}
+
+func TestCancelContract(t *testing.T) {
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+
+	for _, status := range []string{"pending", "signed"} {
+		stub := contractInStatus(t, status)
+		response := stub.invoke(jerry, "cancelContract", "2")
+		checkOK(t, response, "cancelContract from "+status)
+		released := cancellation{}
+		if err := json.Unmarshal(response.Payload, &released); err != nil {
+			t.Fatal(err)
+		}
+		if released.Condition_num != "1" || released.ReleasedDeposit != 5000 || released.Currency != "KRW" {
+			t.Fatalf("cancelContract from %s released %+v, expected 5000 KRW of condition 1", status, released)
+		}
+		cancelled := contract{}
+		json.Unmarshal(stub.State["2"], &cancelled)
+		if cancelled.Status != "cancelled" {
+			t.Fatalf("contract is %s after cancelContract from %s", cancelled.Status, status)
+		}
+	}
+	for _, status := range []string{"completed", "cancelled"} {
+		stub := contractInStatus(t, status)
+		checkErrorCode(t, stub.invoke(tom, "cancelContract", "2"), errCodeInvalidState, "cancelContract from "+status)
+	}
+
+	stub := contractInStatus(t, "pending")
+	checkErrorCode(t, stub.invoke(identity(t, "spike", ""), "cancelContract", "2"), errCodeUnauthorized, "cancelContract by a third party")
+	checkErrorCode(t, stub.invoke(tom, "cancelContract", "3"), errCodeNotFound, "cancelContract of a missing contract")
+}
+
+func TestGetContractForCondition(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+
+	response := stub.invoke(tom, "getContractForCondition", "1")
+	checkErrorCode(t, response, errCodeNotFound, "getContractForCondition before CreateContract")
+	if !strings.Contains(response.Message, "No contract yet for condition 1") {
+		t.Fatalf("unexpected message %s", response.Message)
+	}
+
+	checkOK(t, stub.invoke(tom, "CreateContract", "2", "1"), "CreateContract")
+	response = stub.invoke(tom, "getContractForCondition", "1")
+	checkOK(t, response, "getContractForCondition")
+	found := contract{}
+	if err := json.Unmarshal(response.Payload, &found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Contract_num != "2" || found.Condition_num != "1" {
+		t.Fatalf("getContractForCondition found %+v, expected contract 2", found)
+	}
+}
+
+func TestInitConditonRequiresProperty(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), errCodeReferenceMissing, "initConditon on a missing property")
+	if stub.State["1"] != nil {
+		t.Fatalf("rejected condition was stored: %s", stub.State["1"])
+	}
+
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+
+	// properties, conditions and contracts share one key space
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "100", "100", "tom", "jerry", "5000", "KRW"), errCodeAlreadyExists, "initConditon over the property's key")
+}
+
+func TestCreateContractOnePerCondition(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+
+	checkErrorCode(t, stub.invoke(tom, "CreateContract", "2", "3"), errCodeReferenceMissing, "CreateContract on a missing condition")
+	checkOK(t, stub.invoke(tom, "CreateContract", "2", "1"), "CreateContract")
+	checkErrorCode(t, stub.invoke(tom, "CreateContract", "3", "1"), errCodeAlreadyExists, "second CreateContract on the condition")
+
+	// the check reads the condition~contract index written with the contract
+	indexKey, _ := stub.CreateCompositeKey("condition~contract", []string{"1", "2"})
+	if stub.State[indexKey] == nil {
+		t.Fatalf("CreateContract wrote no condition~contract index entry")
+	}
+
+	// lookups canonicalize their numbers the way CreateContract stored them
+	checkOK(t, stub.invoke(tom, "getContractForCondition", " 01 "), "getContractForCondition with a padded number")
+	checkOK(t, stub.invoke(tom, "getContractChain", "02"), "getContractChain with a padded number")
+	checkErrorCode(t, stub.invoke(tom, "getContractForCondition", "one"), errCodeInvalidArgs, "getContractForCondition with a non-numeric number")
+	checkErrorCode(t, stub.invoke(tom, "getContractChain", "two"), errCodeInvalidArgs, "getContractChain with a non-numeric number")
+}
+
+func TestReindexOwnersPaged(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "jerry"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "102", "house", "seoul jongno 3", "spike"), "initProperty")
+
+	// drop tom's entry and add two dangling ones
+	stub.MockTransactionStart("corrupt")
+	delOwnerIndex(stub, "tom", "100")
+	putOwnerIndex(stub, "ghost", "100")
+	putOwnerIndex(stub, "tom", "999")
+	stub.MockTransactionEnd("corrupt")
+
+	checkErrorCode(t, stub.invoke(agent, "reindexOwners"), errCodeUnauthorized, "reindexOwners by a non-admin")
+	checkErrorCode(t, stub.invoke(admin, "reindexOwners", "resume:100"), errCodeInvalidArgs, "reindexOwners with a foreign nextKey")
+
+	deleted, created, invocations := 0, 0, 0
+	nextKey := ""
+	for {
+		response := stub.invoke(admin, "reindexOwners", nextKey, "2")
+		checkOK(t, response, "reindexOwners "+nextKey)
+		var result struct {
+			Deleted int    `json:"deleted"`
+			Created int    `json:"created"`
+			NextKey string `json:"nextKey"`
+		}
+		if err := json.Unmarshal(response.Payload, &result); err != nil {
+			t.Fatal(err)
+		}
+		deleted, created, nextKey = deleted+result.Deleted, created+result.Created, result.NextKey
+		if invocations++; nextKey == "" || invocations > 10 {
+			break
+		}
+	}
+	if deleted != 2 || created != 1 || nextKey != "" {
+		t.Fatalf("reindexOwners deleted %d and created %d entries, ending at %q; expected 2 and 1", deleted, created, nextKey)
+	}
+	// two pages of prune, then two of rebuild
+	if invocations != 4 {
+		t.Fatalf("reindexOwners took %d invocations, expected 4", invocations)
+	}
+	for owner, expected := range map[string][]string{"tom": {"100"}, "jerry": {"101"}, "spike": {"102"}, "ghost": nil} {
+		if entries, _ := indexEntries(stub, "owner~propertynum", owner); !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("owner index lists %v for %s, expected %v", entries, owner, expected)
+		}
+	}
+}
+
+func TestRepairContractRecordsPaged(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+
+	// contracts as the broken CreateContract stored them, in the condition's shape
+	stub.MockTransactionStart("legacy")
+	stub.PutState("20", []byte(`{"docType":"contract","condition_num":"1","property_num":"100","seller":"tom","buyer":"jerry","deposit":5000}`))
+	stub.PutState("21", []byte(`{"docType":"contract","property_num":"100"}`))
+	stub.MockTransactionEnd("legacy")
+
+	response := stub.invoke(admin, "repairContractRecords", "false", "", "2")
+	checkOK(t, response, "first page")
+	if string(response.Payload) != `{"repaired":[],"unrepairable":[],"nextKey":"20"}` {
+		t.Fatalf("first page returned %s", response.Payload)
+	}
+	response = stub.invoke(admin, "repairContractRecords", "false", "20", "2")
+	checkOK(t, response, "second page")
+	if string(response.Payload) != `{"repaired":["20"],"unrepairable":["21"],"nextKey":""}` {
+		t.Fatalf("second page returned %s", response.Payload)
+	}
+	repaired := contract{}
+	json.Unmarshal(stub.State["20"], &repaired)
+	if repaired.Contract_num != "20" || repaired.Status != "pending" {
+		t.Fatalf("repaired contract is %+v", repaired)
+	}
+	if entries, _ := indexEntries(stub, "condition~contract", "1"); !reflect.DeepEqual(entries, []string{"20"}) {
+		t.Fatalf("condition~contract lists %v, expected [20]", entries)
+	}
+}
+
+// rangePage is one page of getPropertiesByRangeWithPagination.
+type rangePage struct {
+	Results []struct {
+		Key string `json:"Key"`
+	} `json:"Results"`
+	ResponseMetadata struct {
+		RecordsCount int32  `json:"RecordsCount"`
+		Bookmark     string `json:"Bookmark"`
+	} `json:"ResponseMetadata"`
+}
+
+func TestGetPropertiesByRangeWithPagination(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	for propertyNum := 101; propertyNum <= 106; propertyNum++ {
+		checkOK(t, stub.invoke(agent, "initProperty", strconv.Itoa(propertyNum), "house", fmt.Sprintf("seoul jongno %d", propertyNum), "tom"), "initProperty")
+	}
+
+	var keys [][]string
+	bookmark := ""
+	for page := 0; page < 4; page++ {
+		response := stub.invoke(agent, "getPropertiesByRangeWithPagination", "100", "200", "2", bookmark)
+		checkOK(t, response, "getPropertiesByRangeWithPagination")
+		var result rangePage
+		if err := json.Unmarshal(response.Payload, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.ResponseMetadata.RecordsCount != int32(len(result.Results)) {
+			t.Fatalf("page %d counts %d records but holds %d", page, result.ResponseMetadata.RecordsCount, len(result.Results))
+		}
+		var pageKeys []string
+		for _, record := range result.Results {
+			pageKeys = append(pageKeys, record.Key)
+		}
+		keys = append(keys, pageKeys)
+		if bookmark = result.ResponseMetadata.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	expected := [][]string{{"101", "102"}, {"103", "104"}, {"105", "106"}}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("paged through %v, expected %v", keys, expected)
+	}
+}
+
+func TestOwnerIndexFollowsTransferAndDelete(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "transferProperty", "100", "jerry"), "transferProperty")
+
+	if keys := resultKeys(t, stub.invoke(agent, "getPropertiesByOwnerIndexed", "tom"), "getPropertiesByOwnerIndexed"); len(keys) != 0 {
+		t.Fatalf("the previous owner still has %v", keys)
+	}
+	if keys := resultKeys(t, stub.invoke(agent, "getPropertiesByOwnerIndexed", "jerry"), "getPropertiesByOwnerIndexed"); !reflect.DeepEqual(keys, []string{"100"}) {
+		t.Fatalf("the new owner has %v, expected [100]", keys)
+	}
+
+	checkOK(t, stub.invoke(jerry, "deleteProperty", "100"), "deleteProperty")
+	if entries, _ := indexEntries(stub, "owner~propertynum", "jerry"); len(entries) != 0 {
+		t.Fatalf("owner index still lists %v after deleteProperty", entries)
+	}
+}
+
+func TestUpdateDeposit(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+
+	checkErrorCode(t, stub.invoke(identity(t, "spike", ""), "updateDeposit", "1", "1"), errCodeUnauthorized, "updateDeposit by a third party")
+	checkErrorCode(t, stub.invoke(tom, "updateDeposit", "one", "6000"), errCodeInvalidArgs, "updateDeposit of a non-numeric condition")
+
+	// the condition number is canonicalized like the one initConditon stored
+	for i, party := range [][]byte{tom, jerry, admin} {
+		deposit := strconv.Itoa(6000 + i)
+		checkOK(t, stub.invoke(party, "updateDeposit", " 01 ", deposit), "updateDeposit")
+		updated := conditionOfContract{}
+		json.Unmarshal(stub.State["1"], &updated)
+		if strconv.Itoa(updated.Deposit) != deposit {
+			t.Fatalf("deposit is %d, expected %s", updated.Deposit, deposit)
+		}
+	}
+}
+
+func TestInitPropertyNormalizesAddress(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "  Seoul \t Jongno   1 ", "tom"), "initProperty")
+	if address := readTestProperty(t, stub, "100").Address; address != "seoul jongno 1" {
+		t.Fatalf("address is %q, expected %q", address, "seoul jongno 1")
+	}
+
+	checkErrorCode(t, stub.invoke(agent, "initProperty", "101", "house", "\t\t", "tom"), errCodeInvalidArgs, "tab-only address")
+}
+
+func TestInitPropertyStoresPropertyNum(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	response := stub.invoke(agent, "initProperty", " 0100 ", "house", "seoul jongno 1", "tom")
+	checkOK(t, response, "initProperty")
+	if string(response.Payload) != "100" {
+		t.Fatalf("initProperty returned key %q, expected 100", response.Payload)
+	}
+
+	stored := map[string]interface{}{}
+	if err := json.Unmarshal(stub.State["100"], &stored); err != nil {
+		t.Fatalf("property 100: %s", err)
+	}
+	if stored["property_num"] != "100" {
+		t.Fatalf("stored property_num is %v, expected 100", stored["property_num"])
+	}
+}
+
+func TestTransferPropertyToCurrentOwner(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	before := string(stub.State["100"])
+
+	response := stub.invoke(tom, "transferProperty", "100", " Tom ")
+	checkErrorCode(t, response, errCodeInvalidArgs, "transferProperty to the current owner")
+	if !strings.Contains(response.Message, "property already owned by tom") {
+		t.Fatalf("unexpected message %s", response.Message)
+	}
+	if string(stub.State["100"]) != before {
+		t.Fatalf("no-op transfer rewrote the property: %s", stub.State["100"])
+	}
+
+	for _, blank := range []string{"", "   ", "\t"} {
+		checkErrorCode(t, stub.invoke(tom, "transferProperty", "100", blank), errCodeInvalidArgs, fmt.Sprintf("transferProperty to %q", blank))
+	}
+	if string(stub.State["100"]) != before {
+		t.Fatalf("blank transfer rewrote the property: %s", stub.State["100"])
+	}
+}
+
+func TestDeleteAllByType(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "Jerry,Tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	stub.transient = map[string][]byte{"deposit": []byte("6000")}
+	checkOK(t, stub.invoke(tom, "initConditionPrivate", "2", "100", "tom", "spike", "KRW"), "initConditionPrivate")
+	stub.transient = nil
+	checkOK(t, stub.invoke(tom, "CreateContract", "10", "1"), "CreateContract")
+
+	checkErrorCode(t, stub.invoke(tom, "deleteAllByType", "contract", "false"), errCodeUnauthorized, "deleteAllByType by a non-admin")
+	checkErrorCode(t, stub.invoke(admin, "deleteAllByType", "property", "false"), errCodeInvalidState, "deleteAllByType of referenced properties")
+	checkErrorCode(t, stub.invoke(admin, "deleteAllByType", "condition", "false"), errCodeInvalidState, "deleteAllByType of referenced conditions")
+
+	response := stub.invoke(admin, "deleteAllByType", "contract", "true")
+	checkOK(t, response, "deleteAllByType dry run")
+	if string(response.Payload) != `{"keys":["10"],"nextKey":""}` {
+		t.Fatalf("dry run returned %s", response.Payload)
+	}
+	checkOK(t, stub.invoke(admin, "deleteAllByType", "contract", "false"), "deleteAllByType of contracts")
+	if stub.State["10"] != nil {
+		t.Fatal("contract 10 survived deleteAllByType")
+	}
+	for _, index := range [][2]string{{"condition~contract", "1"}, {"property~activecontract", "100"}} {
+		if entries, _ := indexEntries(stub, index[0], index[1]); len(entries) != 0 {
+			t.Fatalf("%s still lists %v", index[0], entries)
+		}
+	}
+
+	// one condition per page: the private deposit goes with its condition
+	response = stub.invoke(admin, "deleteAllByType", "condition", "false", "", "1")
+	checkOK(t, response, "first page of conditions")
+	if string(response.Payload) != `{"deleted":1,"nextKey":"2"}` {
+		t.Fatalf("first page returned %s", response.Payload)
+	}
+	if stub.State["1"] != nil || stub.State["2"] == nil {
+		t.Fatal("first page deleted the wrong condition")
+	}
+	response = stub.invoke(admin, "deleteAllByType", "condition", "false", "2", "1")
+	checkOK(t, response, "second page of conditions")
+	if string(response.Payload) != `{"deleted":1,"nextKey":""}` {
+		t.Fatalf("second page returned %s", response.Payload)
+	}
+	if deposit, _ := stub.GetPrivateData(depositCollection, "2"); deposit != nil {
+		t.Fatalf("private deposit survived its condition: %s", deposit)
+	}
+
+	checkOK(t, stub.invoke(admin, "deleteAllByType", "property", "false"), "deleteAllByType of properties")
+	for _, owner := range []string{"tom", "jerry"} {
+		if entries, _ := indexEntries(stub, "owner~propertynum", owner); len(entries) != 0 {
+			t.Fatalf("owner index still lists %v for %s", entries, owner)
+		}
+	}
+}
+
+func TestTransferPropertyRequiresOwner(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+	spike := identity(t, "spike", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	checkErrorCode(t, stub.invoke(spike, "transferProperty", "100", "spike"), errCodeUnauthorized, "transferProperty by a non-owner")
+	checkErrorCode(t, stub.invoke(nil, "transferProperty", "100", "spike"), errCodeUnauthorized, "transferProperty without a creator")
+	if owner := readTestProperty(t, stub, "100").Owner; owner != "tom" {
+		t.Fatalf("owner is %s, expected tom", owner)
+	}
+
+	// another organization's CA can enroll its own "tom"
+	if ownerMSP := readTestProperty(t, stub, "100").OwnerMSP; ownerMSP != "Org1MSP" {
+		t.Fatalf("owner_msp is %q, expected Org1MSP", ownerMSP)
+	}
+	checkErrorCode(t, stub.invoke(identityInMSP(t, "Org2MSP", "tom", ""), "transferProperty", "100", "spike"), errCodeUnauthorized, "transferProperty by a namesake in another MSP")
+	checkErrorCode(t, stub.invoke(identityInMSP(t, "Org2MSP", "tom", ""), "deleteProperty", "100"), errCodeUnauthorized, "deleteProperty by a namesake in another MSP")
+	checkOK(t, stub.invoke(tom, "updateProperty", "100", `{"name":"villa"}`), "updateProperty by the owner")
+
+	checkOK(t, stub.invoke(admin, "transferProperty", "100", "jerry"), "transferProperty by an admin")
+	checkErrorCode(t, stub.invoke(tom, "transferProperty", "100", "tom"), errCodeUnauthorized, "transferProperty by the previous owner")
+	checkOK(t, stub.invoke(jerry, "transferProperty", "100", "spike"), "transferProperty by the owner")
+	if owner := readTestProperty(t, stub, "100").Owner; owner != "spike" {
+		t.Fatalf("owner is %s, expected spike", owner)
+	}
+}
+
+func TestInitPropertyRequiresListingRole(t *testing.T) {
+	stub := newTestStub(true)
+
+	checkOK(t, stub.invoke(identity(t, "agent", "agent"), "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty by an agent")
+	checkOK(t, stub.invoke(identity(t, "admin", "admin"), "initProperty", "101", "house", "seoul jongno 2", "tom"), "initProperty by an admin")
+
+	checkErrorCode(t, stub.invoke(identity(t, "buyer", "buyer"), "initProperty", "102", "house", "seoul jongno 3", "tom"), errCodeUnauthorized, "initProperty by a buyer")
+	checkErrorCode(t, stub.invoke(identity(t, "tom", ""), "initProperty", "103", "house", "seoul jongno 4", "tom"), errCodeUnauthorized, "initProperty without a role")
+	if stub.State["102"] != nil || stub.State["103"] != nil {
+		t.Fatalf("a denied initProperty stored a property")
+	}
+}
+
+func TestRecordsCarryCreatedAt(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "CreateContract", "2", "1"), "CreateContract")
+
+	for _, key := range []string{"100", "1", "2"} {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal(stub.State[key], &record); err != nil {
+			t.Fatalf("record %s: %s", key, err)
+		}
+		createdAt, _ := record["createdAt"].(string)
+		if _, err := time.Parse(time.RFC3339, createdAt); err != nil {
+			t.Fatalf("%s %s has createdAt %q: %s", record["docType"], key, createdAt, err)
+		}
+	}
+}
+
+func TestInitConditonParties(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "Tom", "5000", "KRW"), errCodeInvalidArgs, "seller and buyer equal")
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "1", "100", "jerry", "tom", "5000", "KRW"), errCodeInvalidArgs, "seller not the owner")
+	if _, found := stub.State["1"]; found {
+		t.Fatal("a rejected condition was stored")
+	}
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "Tom", "jerry", "5000", "KRW"), "initConditon by the owner")
+}
+
+func TestTransferPropertyBlockedByActiveContract(t *testing.T) {
+	// no rich query: the active contract is found through the property~activecontract index
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "CreateContract", "2", "1"), "CreateContract")
+
+	response := stub.invoke(tom, "transferProperty", "100", "spike")
+	checkErrorCode(t, response, errCodeInvalidState, "transferProperty during a pending contract")
+	if !strings.Contains(response.Message, "active contract 2") {
+		t.Fatalf("unexpected message %s", response.Message)
+	}
+
+	checkOK(t, stub.invoke(tom, "cancelContract", "2"), "cancelContract")
+	checkOK(t, stub.invoke(tom, "transferProperty", "100", "spike"), "transferProperty after the contract was cancelled")
+}
+
+func TestQueryPropertiesRequiresSelector(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+
+	response := stub.invoke(agent, "queryProperties", `{"owner":"tom"}`)
+	checkErrorCode(t, response, errCodeInvalidArgs, "query without a selector")
+	if !strings.Contains(response.Message, "query must contain a 'selector' field") {
+		t.Fatalf("unhelpful error %s", response.Message)
+	}
+	checkErrorCode(t, stub.invoke(agent, "queryProperties", `owner = tom`), errCodeInvalidArgs, "query that is not JSON")
+}
+
+func TestGetPropertiesByRangeSorted(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	checkOK(t, stub.invoke(agent, "initProperty", "9", "apartment", "seoul jongno 9", "jerry"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "10", "villa", "seoul jongno 10", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 100", "amy"), "initProperty")
+
+	for _, test := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"1", "99"}, []string{"10", "100", "9"}},
+		{[]string{"1", "99", "name"}, []string{"9", "100", "10"}},
+		{[]string{"1", "99", "owner"}, []string{"100", "9", "10"}},
+		{[]string{"1", "99", "property_num"}, []string{"9", "10", "100"}},
+	} {
+		keys := resultKeys(t, stub.invoke(agent, "getPropertiesByRange", test.args...), "getPropertiesByRange")
+		if !reflect.DeepEqual(keys, test.expected) {
+			t.Fatalf("getPropertiesByRange %v returned %v, expected %v", test.args, keys, test.expected)
+		}
+	}
+	checkErrorCode(t, stub.invoke(agent, "getPropertiesByRange", "1", "99", "address"), errCodeInvalidArgs, "unknown sort field")
+}
+
+func TestUpdatePropertyMergesFields(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	checkOK(t, stub.invoke(tom, "updateProperty", "100", `{"name":"villa"}`), "updateProperty name")
+	if updated := readTestProperty(t, stub, "100"); updated.Name != "villa" || updated.Address != "seoul jongno 1" {
+		t.Fatalf("name-only update left %+v", updated)
+	}
+	checkOK(t, stub.invoke(tom, "updateProperty", "100", `{"address":"busan haeundae 2"}`), "updateProperty address")
+	if updated := readTestProperty(t, stub, "100"); updated.Name != "villa" || updated.Address != "busan haeundae 2" {
+		t.Fatalf("address-only update left %+v", updated)
+	}
+	checkOK(t, stub.invoke(tom, "updateProperty", "100", `{"name":"cottage","address":"jeju 3","owner":"jerry"}`), "updateProperty name and address")
+	if updated := readTestProperty(t, stub, "100"); updated.Name != "cottage" || updated.Address != "jeju 3" || updated.Owner != "tom" {
+		t.Fatalf("update of both fields left %+v", updated)
+	}
+
+	checkErrorCode(t, stub.invoke(tom, "updateProperty", "100", `{"property_num":"101"}`), errCodeInvalidArgs, "updateProperty of property_num")
+}
+
+func TestReadContract(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "CreateContract", "2", "1"), "CreateContract")
+
+	var result struct {
+		Contract_num string               `json:"contract_num"`
+		Condition    *conditionOfContract `json:"condition"`
+		Note         string               `json:"note"`
+	}
+	response := stub.invoke(tom, "readContract", "2")
+	checkOK(t, response, "readContract")
+	if err := json.Unmarshal(response.Payload, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Contract_num != "2" || result.Condition == nil || result.Condition.Condition_num != "1" || result.Note != "" {
+		t.Fatalf("readContract of an intact contract returned %s", response.Payload)
+	}
+	checkErrorCode(t, stub.invoke(tom, "readContract", "1"), errCodeNotFound, "readContract of a condition")
+
+	// break the reference the way a partial delete or bad migration would
+	delete(stub.State, "1")
+	response = stub.invoke(tom, "readContract", "2")
+	checkOK(t, response, "readContract with a missing condition")
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(response.Payload, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if condition, found := fields["condition"]; !found || string(condition) != "null" {
+		t.Fatalf("readContract of a broken contract returned %s, expected \"condition\":null", response.Payload)
+	}
+	if note := string(fields["note"]); len(note) <= 2 {
+		t.Fatalf("readContract of a broken contract has no note: %s", response.Payload)
+	}
+}
+
+func TestSellPropertyRequiresBuyer(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	before := string(stub.State["100"])
+
+	for _, blank := range []string{"", "   ", "\t"} {
+		checkErrorCode(t, stub.invoke(tom, "sellProperty", "100", blank, "350000"), errCodeInvalidArgs, fmt.Sprintf("sellProperty to %q", blank))
+	}
+	if string(stub.State["100"]) != before {
+		t.Fatalf("blank sale rewrote the property: %s", stub.State["100"])
+	}
+}
+
+func TestIdempotencyKeyReplay(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+
+	response := stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom", "key-1")
+	checkOK(t, response, "initProperty")
+	created := string(stub.State["100"])
+
+	// without the key the same request fails, with it the retry gets the original result
+	checkErrorCode(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), errCodeAlreadyExists, "initProperty without the idempotencyKey")
+	for i := 0; i < 2; i++ {
+		replayed := stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom", "key-1")
+		checkOK(t, replayed, "replayed initProperty")
+		if string(replayed.Payload) != string(response.Payload) {
+			t.Fatalf("replay returned %q, expected %q", replayed.Payload, response.Payload)
+		}
+	}
+	if string(stub.State["100"]) != created {
+		t.Fatalf("replay rewrote the property: %s", stub.State["100"])
+	}
+
+	checkErrorCode(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "tom", "key-1"), errCodeInvalidArgs, "idempotencyKey reused for another request")
+	checkErrorCode(t, stub.invoke(identity(t, "agent2", "agent"), "initProperty", "100", "house", "seoul jongno 1", "tom", "key-1"), errCodeUnauthorized, "idempotencyKey replayed by another invoker")
+}
+
+func TestInputLengthLimits(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+
+	// limits count characters, not bytes
+	checkOK(t, stub.invoke(agent, "initProperty", "100", strings.Repeat("가", maxNameLength), "seoul jongno 1", "tom"), "initProperty with the longest name")
+	checkErrorCode(t, stub.invoke(agent, "initProperty", "101", strings.Repeat("가", maxNameLength+1), "seoul jongno 1", "tom"), errCodeInvalidArgs, "initProperty with a name over the limit")
+	checkOK(t, stub.invoke(agent, "initProperty", "102", "house", strings.Repeat("a", maxAddressLength), "tom"), "initProperty with the longest address")
+	checkErrorCode(t, stub.invoke(agent, "initProperty", "103", "house", strings.Repeat("a", maxAddressLength+1), "tom"), errCodeInvalidArgs, "initProperty with an address over the limit")
+	checkOK(t, stub.invoke(agent, "initProperty", "104", "house", "seoul jongno 4", strings.Repeat("a", maxPartyLength)), "initProperty with the longest owner")
+	checkErrorCode(t, stub.invoke(agent, "initProperty", "105", "house", "seoul jongno 5", strings.Repeat("a", maxPartyLength+1)), errCodeInvalidArgs, "initProperty with an owner over the limit")
+
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", strings.Repeat("b", maxPartyLength), "5000", "KRW"), "initConditon with the longest buyer")
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "2", "100", "tom", strings.Repeat("b", maxPartyLength+1), "5000", "KRW"), errCodeInvalidArgs, "initConditon with a buyer over the limit")
+}
+
+func TestGetMarketStatsByOwner(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "102", "house", "seoul jongno 3", "Tom,Amy"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "103", "house", "seoul jongno 4", "jerry"), "initProperty")
+
+	// an offer without a contract and one with a pending contract are active ...
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "2", "101", "tom", "amy", "3000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "CreateContract", "20", "2"), "CreateContract")
+	// ... one whose contract was cancelled is not, and neither is tom buying
+	checkOK(t, stub.invoke(tom, "initConditon", "3", "102", "tom", "bob", "700", "USD"), "initConditon")
+	checkOK(t, stub.invoke(tom, "CreateContract", "30", "3"), "CreateContract")
+	checkOK(t, stub.invoke(tom, "cancelContract", "30"), "cancelContract")
+	checkOK(t, stub.invoke(jerry, "initConditon", "4", "103", "jerry", "tom", "900", "KRW"), "initConditon")
+
+	response := stub.invoke(agent, "getMarketStatsByOwner", " Tom ")
+	checkOK(t, response, "getMarketStatsByOwner")
+	var stats marketStats
+	if err := json.Unmarshal(response.Payload, &stats); err != nil {
+		t.Fatal(err)
+	}
+	expected := marketStats{Owner: "tom", PropertyCount: 3, ActiveConditionCount: 2, TotalDeposit: map[string]int{"KRW": 8000}}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("getMarketStatsByOwner returned %+v, expected %+v", stats, expected)
+	}
+
+	response = stub.invoke(agent, "getMarketStatsByOwner", "bob")
+	checkOK(t, response, "getMarketStatsByOwner")
+	var empty marketStats
+	if err := json.Unmarshal(response.Payload, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if empty.PropertyCount != 0 || empty.ActiveConditionCount != 0 || len(empty.TotalDeposit) != 0 {
+		t.Fatalf("getMarketStatsByOwner for an owner without records returned %+v", empty)
+	}
+}
+
+func TestGetConditionDepositHash(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	stub.transient = map[string][]byte{"deposit": []byte("6000")}
+	checkOK(t, stub.invoke(tom, "initConditionPrivate", "1", "100", "tom", "jerry", "KRW"), "initConditionPrivate")
+	stub.transient = nil
+
+	response := stub.invoke(tom, "getConditionDepositHash", "1")
+	checkOK(t, response, "getConditionDepositHash")
+	if len(response.Payload) <= 0 {
+		t.Fatal("getConditionDepositHash returned an empty hash")
+	}
+	expected := sha256.Sum256(stub.PvtState[depositCollection]["1"])
+	if string(response.Payload) != hex.EncodeToString(expected[:]) {
+		t.Fatalf("getConditionDepositHash returned %s, expected the hash of %s", response.Payload, stub.PvtState[depositCollection]["1"])
+	}
+	again := stub.invoke(identity(t, "jerry", ""), "getConditionDepositHash", "1")
+	checkOK(t, again, "getConditionDepositHash")
+	if string(again.Payload) != string(response.Payload) {
+		t.Fatalf("hash changed from %s to %s", response.Payload, again.Payload)
+	}
+
+	checkErrorCode(t, stub.invoke(tom, "getConditionDepositHash", "2"), errCodeNotFound, "getConditionDepositHash without a private deposit")
+}
+
+func TestConfigDocTypes(t *testing.T) {
+	stub := newTestStub(true)
+	admin := identity(t, "admin", "admin")
+	agent := identity(t, "agent", "agent")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	checkErrorCode(t, stub.invoke(agent, "setConfig", `{"docTypes":["property"]}`), errCodeUnauthorized, "setConfig by a non-admin")
+	checkErrorCode(t, stub.invoke(admin, "setConfig", `{"docTypes":[]}`), errCodeInvalidArgs, "setConfig without docTypes")
+	checkErrorCode(t, stub.invoke(admin, "setConfig", `{"docTypes":["property","invoice"]}`), errCodeInvalidArgs, "setConfig with an unknown docType")
+
+	checkOK(t, stub.invoke(agent, "getAllRecordsByType", "condition"), "getAllRecordsByType with the default docTypes")
+	checkOK(t, stub.invoke(admin, "setConfig", `{"docTypes":["property"]}`), "setConfig")
+	if keys := resultKeys(t, stub.invoke(agent, "getAllRecordsByType", "property"), "getAllRecordsByType"); !reflect.DeepEqual(keys, []string{"100"}) {
+		t.Fatalf("getAllRecordsByType returned %v, expected [100]", keys)
+	}
+	checkErrorCode(t, stub.invoke(agent, "getAllRecordsByType", "condition"), errCodeInvalidArgs, "getAllRecordsByType of a docType left out of the config")
+	checkErrorCode(t, stub.invoke(admin, "deleteAllByType", "condition", "true"), errCodeInvalidArgs, "deleteAllByType of a docType left out of the config")
+}
+
+func TestDeleteCondition(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+	spike := identity(t, "spike", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "2", "100", "tom", "jerry", "6000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "3", "100", "tom", "jerry", "7000", "KRW"), "initConditon")
+
+	checkErrorCode(t, stub.invoke(spike, "deleteCondition", "1"), errCodeUnauthorized, "delete by a third party")
+	checkOK(t, stub.invoke(jerry, "deleteCondition", "1"), "delete by the buyer")
+	checkOK(t, stub.invoke(tom, "deleteCondition", "2"), "delete by the seller")
+	checkOK(t, stub.invoke(admin, "deleteCondition", "3"), "delete by an admin")
+	checkErrorCode(t, stub.invoke(tom, "deleteCondition", "1"), errCodeNotFound, "delete of a deleted condition")
+}
+
+func TestDeleteContract(t *testing.T) {
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	jerry := identity(t, "jerry", "")
+	spike := identity(t, "spike", "")
+
+	for _, status := range []string{"pending", "signed"} {
+		stub := contractInStatus(t, status)
+		checkErrorCode(t, stub.invoke(tom, "deleteContract", "2"), errCodeInvalidState, "deleteContract from "+status)
+		checkErrorCode(t, stub.invoke(admin, "deleteContract", "2"), errCodeInvalidState, "deleteContract by an admin from "+status)
+	}
+	for _, status := range []string{"completed", "cancelled"} {
+		for _, deleter := range []struct {
+			name     string
+			identity []byte
+		}{{"seller", tom}, {"buyer", jerry}, {"admin", admin}} {
+			stub := contractInStatus(t, status)
+			checkErrorCode(t, stub.invoke(spike, "deleteContract", "2"), errCodeUnauthorized, "deleteContract by a third party from "+status)
+			checkOK(t, stub.invoke(deleter.identity, "deleteContract", "2"), "deleteContract by the "+deleter.name+" from "+status)
+			if stub.State["2"] != nil {
+				t.Fatalf("contract still stored after deleteContract from %s", status)
+			}
+			// the condition is free again once its contract is gone
+			checkOK(t, stub.invoke(tom, "deleteCondition", "1"), "deleteCondition after deleteContract from "+status)
+		}
+	}
+}
+
+func TestUpdatePropertyStaleVersion(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	version := fmt.Sprint(readTestProperty(t, stub, "100").Version)
+
+	// two clients read the same version; the second write must not clobber the first
+	checkOK(t, stub.invoke(tom, "updateProperty", "100", `{"name":"villa"}`, version), "updateProperty")
+	checkErrorCode(t, stub.invoke(tom, "updateProperty", "100", `{"name":"cottage"}`, version), errCodeConflict, "updateProperty with a stale version")
+	checkErrorCode(t, stub.invoke(tom, "transferProperty", "100", "jerry", "", version), errCodeConflict, "transferProperty with a stale version")
+
+	updated := readTestProperty(t, stub, "100")
+	if updated.Name != "villa" || updated.Owner != "tom" {
+		t.Fatalf("stale writes changed the property: %+v", updated)
+	}
+}
+
+func TestReadValues(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "villa", "seoul jongno 2", "jerry"), "initProperty")
+
+	for _, args := range [][]string{{"100", "999", "101"}, {`["100","999","101"]`}} {
+		response := stub.invoke(agent, "readValues", args...)
+		checkOK(t, response, "readValues")
+		var values map[string]*property
+		if err := json.Unmarshal(response.Payload, &values); err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 3 {
+			t.Fatalf("readValues %v returned %s, expected 3 keys", args, response.Payload)
+		}
+		if values["100"] == nil || values["100"].Name != "house" || values["101"] == nil || values["101"].Name != "villa" {
+			t.Fatalf("readValues %v returned %s", args, response.Payload)
+		}
+		if missing, found := values["999"]; !found || missing != nil {
+			t.Fatalf("readValues %v returned %s, expected null for 999", args, response.Payload)
+		}
+	}
+}
+
+func TestDepositCurrency(t *testing.T) {
+	stub := newTestStub(true)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "XYZ"), errCodeInvalidArgs, "initConditon with an unknown currency")
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", ""), errCodeInvalidArgs, "initConditon without a currency")
+
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "2", "100", "tom", "spike", "6000", " krw "), "initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "3", "100", "tom", "tyke", "700", "USD"), "initConditon")
+
+	response := stub.invoke(agent, "getAggregateDepositByProperty", "100")
+	checkOK(t, response, "getAggregateDepositByProperty")
+	var aggregate depositAggregate
+	if err := json.Unmarshal(response.Payload, &aggregate); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"KRW": 11000, "USD": 700}
+	if aggregate.OfferCount != 3 || !reflect.DeepEqual(aggregate.TotalDeposit, expected) {
+		t.Fatalf("getAggregateDepositByProperty returned %s, expected totals %v", response.Payload, expected)
+	}
+}
+
+func TestValidatePropertyAgreesWithInitProperty(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+
+	for _, args := range [][]string{
+		{"100", "house", "seoul jongno 1", "tom"},
+		{"100", "villa", "seoul jongno 2", "jerry"},
+		{"one", "house", "seoul jongno 1", "tom"},
+		{"0", "house", "seoul jongno 1", "tom"},
+		{"101", "house", " \t ", "tom"},
+		{"102", strings.Repeat("a", maxNameLength+1), "seoul jongno 1", "tom"},
+		{"103", "house", "seoul jongno 1", " , "},
+	} {
+		keyCount := stub.Keys.Len()
+		response := stub.invoke(agent, "validateProperty", args...)
+		checkOK(t, response, "validateProperty")
+		if stub.Keys.Len() != keyCount {
+			t.Fatalf("validateProperty %v wrote state", args)
+		}
+		var result struct {
+			Valid  bool     `json:"valid"`
+			Errors []string `json:"errors"`
+		}
+		if err := json.Unmarshal(response.Payload, &result); err != nil {
+			t.Fatal(err)
+		}
+
+		created := stub.invoke(agent, "initProperty", args...)
+		if result.Valid != (created.Status == shim.OK) {
+			t.Fatalf("validateProperty %v returned %s but initProperty returned %d %s", args, response.Payload, created.Status, created.Message)
+		}
+		if !result.Valid && (len(result.Errors) != 1 || !strings.Contains(created.Message, result.Errors[0])) {
+			t.Fatalf("validateProperty %v reported %v but initProperty failed with %s", args, result.Errors, created.Message)
+		}
+	}
+}
+
+func TestPaginationBookmarks(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	for propertyNum := 101; propertyNum <= 105; propertyNum++ {
+		checkOK(t, stub.invoke(agent, "initProperty", strconv.Itoa(propertyNum), "house", fmt.Sprintf("seoul jongno %d", propertyNum), "tom"), "initProperty")
+	}
+
+	// page until the bookmark comes back empty; the last page is short
+	var bookmarks []string
+	bookmark := ""
+	for page := 0; page < 5; page++ {
+		response := stub.invoke(agent, "getPropertiesByRangeWithPagination", "100", "200", "2", bookmark)
+		checkOK(t, response, "getPropertiesByRangeWithPagination")
+		var result rangePage
+		if err := json.Unmarshal(response.Payload, &result); err != nil {
+			t.Fatal(err)
+		}
+		bookmark = result.ResponseMetadata.Bookmark
+		bookmarks = append(bookmarks, bookmark)
+		if bookmark == "" {
+			if len(result.Results) != 1 || result.Results[0].Key != "105" {
+				t.Fatalf("the last page holds %+v, expected only 105", result.Results)
+			}
+			break
+		}
+	}
+	if !reflect.DeepEqual(bookmarks, []string{"103", "105", ""}) {
+		t.Fatalf("paged with bookmarks %q, expected the last one empty", bookmarks)
+	}
+
+	checkErrorCode(t, stub.invoke(agent, "getPropertiesByRangeWithPagination", "100", "200", "2", "300"), errCodeInvalidArgs, "a bookmark from another range")
+	checkErrorCode(t, stub.invoke(agent, "queryPropertiesWithPagination", `{"selector":{"docType":"property"}}`, "2", "not a bookmark!"), errCodeInvalidArgs, "a garbage query bookmark")
+}
+
+func TestCreateAutoUniqueKeys(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+
+	created := map[string]string{}
+	create := func(identity []byte, function string, docType string, args ...string) string {
+		t.Helper()
+		response := stub.invoke(identity, function, args...)
+		checkOK(t, response, function)
+		key := string(response.Payload)
+		if _, err := parsePositiveInt("key", key); err != nil {
+			t.Fatalf("%s returned key %q: %s", function, key, err)
+		}
+		if other, taken := created[key]; taken {
+			t.Fatalf("%s returned key %s, already used by %s", function, key, other)
+		}
+		created[key] = function
+		record := map[string]interface{}{}
+		if err := json.Unmarshal(stub.State[key], &record); err != nil {
+			t.Fatalf("%s %s: %s", function, key, err)
+		}
+		if record[docType+"_num"] != key {
+			t.Fatalf("%s stored %s_num %v under key %s", function, docType, record[docType+"_num"], key)
+		}
+		return key
+	}
+
+	first := create(agent, "createPropertyAuto", "property", "house", "seoul jongno 1", "tom")
+	second := create(agent, "createPropertyAuto", "property", "villa", "seoul jongno 2", "tom")
+	firstCondition := create(tom, "createConditionAuto", "condition", first, "tom", "jerry", "5000", "KRW")
+	create(tom, "createConditionAuto", "condition", second, "tom", "jerry", "6000", "KRW")
+	create(tom, "createContractAuto", "contract", firstCondition)
+}
+
+func TestGetConditionWithContractStatus(t *testing.T) {
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+
+	contractStatusOf := func(stub *testStub) string {
+		t.Helper()
+		response := stub.invoke(agent, "getConditionWithContractStatus", "1")
+		checkOK(t, response, "getConditionWithContractStatus")
+		var result struct {
+			Condition_num  string `json:"condition_num"`
+			Seller         string `json:"seller"`
+			ContractStatus string `json:"contractStatus"`
+		}
+		if err := json.Unmarshal(response.Payload, &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Condition_num != "1" || result.Seller != "tom" {
+			t.Fatalf("getConditionWithContractStatus left out the condition: %s", response.Payload)
+		}
+		return result.ContractStatus
+	}
+
+	stub := newTestStub(false)
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	if status := contractStatusOf(stub); status != "none" {
+		t.Fatalf("a condition without a contract has contractStatus %s", status)
+	}
+	checkErrorCode(t, stub.invoke(agent, "getConditionWithContractStatus", "100"), errCodeNotFound, "getConditionWithContractStatus of a property")
+
+	for _, status := range []string{"pending", "signed", "completed", "cancelled"} {
+		if got := contractStatusOf(contractInStatus(t, status)); got != status {
+			t.Fatalf("a condition whose contract is %s has contractStatus %s", status, got)
+		}
+	}
+}
+
+func TestInitConditonOneActiveDealPerProperty(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	// competing offers are allowed until one becomes a contract
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "first offer")
+	checkOK(t, stub.invoke(tom, "initConditon", "2", "100", "tom", "spike", "6000", "KRW"), "competing offer")
+	checkOK(t, stub.invoke(tom, "CreateContract", "10", "1"), "CreateContract")
+
+	checkErrorCode(t, stub.invoke(tom, "initConditon", "3", "100", "tom", "tyke", "7000", "KRW"), errCodeInvalidState, "offer on a property under contract")
+	checkErrorCode(t, stub.invoke(tom, "CreateContract", "11", "2"), errCodeInvalidState, "contract from a competing offer")
+
+	// once the deal is cancelled the listing takes offers again
+	checkOK(t, stub.invoke(tom, "cancelContract", "10"), "cancelContract")
+	checkOK(t, stub.invoke(tom, "initConditon", "3", "100", "tom", "tyke", "7000", "KRW"), "offer after the contract was cancelled")
+}
+
+func TestSellPropertySaleHistory(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+
+	checkErrorCode(t, stub.invoke(identity(t, "tom", ""), "sellProperty", "100", "jerry", "0"), errCodeInvalidArgs, "sellProperty at no price")
+	sales := []saleRecord{{"jerry", 350000, ""}, {"amy", 400000, ""}, {"bob", 420000, ""}}
+	seller := "tom"
+	for _, sale := range sales {
+		checkOK(t, stub.invoke(identity(t, seller, ""), "sellProperty", "100", sale.Buyer, strconv.Itoa(sale.Price)), "sellProperty")
+		seller = sale.Buyer
+	}
+
+	sold := readTestProperty(t, stub, "100")
+	if sold.Owner != "bob" || len(sold.SaleHistory) != len(sales) {
+		t.Fatalf("after %d sales the property is %+v", len(sales), sold)
+	}
+	previous := time.Time{}
+	for i, sale := range sold.SaleHistory {
+		if sale.Buyer != sales[i].Buyer || sale.Price != sales[i].Price {
+			t.Fatalf("sale %d is %+v, expected %+v", i, sale, sales[i])
+		}
+		soldAt, err := time.Parse(time.RFC3339, sale.Timestamp)
+		if err != nil || soldAt.Before(previous) {
+			t.Fatalf("sale %d has timestamp %q after %s", i, sale.Timestamp, previous)
+		}
+		previous = soldAt
+	}
+}
+
+func TestQueryScanFallback(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "jerry"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "102", "house", "seoul jongno 3", "Jerry,Tom"), "initProperty")
+
+	// without the flag a missing rich query engine is an error, not a silent full scan
+	checkErrorCode(t, stub.invoke(agent, "queryPropertiesByOwner", "tom"), errCodeInternal, "queryPropertiesByOwner without scan")
+	checkErrorCode(t, stub.invoke(agent, "getAllRecordsByType", "property"), errCodeInternal, "getAllRecordsByType without scan")
+	checkErrorCode(t, stub.invoke(agent, "queryPropertiesByOwner", "tom", "full"), errCodeInvalidArgs, "queryPropertiesByOwner with an unknown option")
+
+	if keys := resultKeys(t, stub.invoke(agent, "queryPropertiesByOwner", "TOM", "scan"), "queryPropertiesByOwner"); !reflect.DeepEqual(keys, []string{"100", "102"}) {
+		t.Fatalf("queryPropertiesByOwner returned %v, expected [100 102]", keys)
+	}
+	if keys := resultKeys(t, stub.invoke(agent, "getAllRecordsByType", "property", "scan"), "getAllRecordsByType"); !reflect.DeepEqual(keys, []string{"100", "101", "102"}) {
+		t.Fatalf("getAllRecordsByType returned %v, expected [100 101 102]", keys)
+	}
+	if keys := resultKeys(t, stub.invoke(agent, "getAllRecordsByType", "condition", "scan"), "getAllRecordsByType"); len(keys) != 0 {
+		t.Fatalf("getAllRecordsByType returned %v, expected no conditions", keys)
+	}
+}
+
+func TestAmendContract(t *testing.T) {
+	stub := newTestStub(false)
+	agent := identity(t, "agent", "agent")
+	admin := identity(t, "admin", "admin")
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "villa", "seoul jongno 2", "tom"), "initProperty")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "3", "100", "tom", "jerry", "4500", "KRW"), "revised initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "5", "101", "tom", "jerry", "4500", "KRW"), "initConditon on another property")
+	checkOK(t, stub.invoke(tom, "CreateContract", "2", "1"), "CreateContract")
+
+	checkErrorCode(t, stub.invoke(tom, "amendContract", "2", "5"), errCodeInvalidArgs, "amendContract to a condition on another property")
+	checkOK(t, stub.invoke(tom, "amendContract", "2", "3"), "amendContract of a pending contract")
+	amended := contract{}
+	if err := json.Unmarshal(stub.State["2"], &amended); err != nil {
+		t.Fatal(err)
+	}
+	if amended.Condition_num != "3" || !reflect.DeepEqual(amended.AmendmentHistory, []string{"1"}) {
+		t.Fatalf("amended contract is %+v", amended)
+	}
+	if entries, _ := indexEntries(stub, "condition~contract", "3"); len(entries) != 1 {
+		t.Fatalf("the revised condition has contract index entries %v", entries)
+	}
+
+	checkOK(t, stub.invoke(admin, "signContract", "2"), "signContract")
+	checkErrorCode(t, stub.invoke(tom, "amendContract", "2", "1"), errCodeInvalidState, "amendContract of a signed contract")
+	if signed := string(stub.State["2"]); !strings.Contains(signed, `"condition_num":"3"`) {
+		t.Fatalf("a rejected amendment rewrote the contract: %s", signed)
+	}
+}
+
+func TestQueriesOnEmptyState(t *testing.T) {
+	stub := newTestStub(true)
+	tom := identity(t, "tom", "")
+
+	queries := [][]string{
+		{"getPropertiesByRange", "1", "999"},
+		{"getPropertiesByRange", "1", "999", "name"},
+		{"getPropertiesByOwnerIndexed", "tom"},
+		{"queryPropertiesByOwner", "tom"},
+		{"queryProperties", `{"selector":{"docType":"property"}}`},
+		{"getAllRecordsByType", "property"},
+		{"getPropertiesByAddressPrefix", "seoul"},
+		{"queryConditionsByDepositRange", "0", "10000"},
+		{"getConditionsByProperty", "100"},
+		{"getConditionsBySeller", "tom"},
+		{"getConditionsByBuyer", "tom"},
+		{"queryContractsByStatus", "pending"},
+		{"getPendingApprovalsForParty", "tom"},
+		{"getContractsInvolvingParty", "tom"},
+		{"searchProperties", `{"owner":"tom"}`},
+	}
+	for _, query := range queries {
+		response := stub.invoke(tom, query[0], query[1:]...)
+		checkOK(t, response, query[0])
+		if string(response.Payload) != "[]" {
+			t.Fatalf("%v returned %q on empty state, expected []", query, response.Payload)
+		}
+	}
+
+	// the scan fallback answers an empty key space with [] as well
+	stub.richQuery = false
+	for _, query := range [][]string{{"queryPropertiesByOwner", "tom", "scan"}, {"getAllRecordsByType", "contract", "scan"}} {
+		response := stub.invoke(tom, query[0], query[1:]...)
+		checkOK(t, response, query[0])
+		if string(response.Payload) != "[]" {
+			t.Fatalf("%v returned %q on empty state, expected []", query, response.Payload)
+		}
+	}
+	stub.richQuery = true
+
+	// aggregations return their object with an empty, never null, total
+	for _, query := range [][]string{{"getAggregateDepositByProperty", "100"}, {"getMarketStatsByOwner", "tom"}} {
+		response := stub.invoke(tom, query[0], query[1:]...)
+		checkOK(t, response, query[0])
+		var aggregate struct {
+			TotalDeposit map[string]int `json:"totalDeposit"`
+		}
+		if err := json.Unmarshal(response.Payload, &aggregate); err != nil || aggregate.TotalDeposit == nil || len(aggregate.TotalDeposit) != 0 {
+			t.Fatalf("%v returned %q on empty state, expected an empty totalDeposit", query, response.Payload)
+		}
+	}
+}
+
+func TestQueryResultCap(t *testing.T) {
+	stub := newTestStub(true)
+	admin := identity(t, "admin", "admin")
+	agent := identity(t, "agent", "agent")
+	checkOK(t, stub.invoke(admin, "setConfig", `{"maxQueryResults":2}`), "setConfig")
+	checkOK(t, stub.invoke(agent, "initProperty", "100", "house", "seoul jongno 1", "tom"), "initProperty")
+	checkOK(t, stub.invoke(agent, "initProperty", "101", "house", "seoul jongno 2", "tom"), "initProperty")
+
+	queries := [][]string{
+		{"getAllRecordsByType", "property"},
+		{"queryProperties", `{"selector":{"docType":"property"}}`},
+		{"queryPropertiesByOwner", "tom"},
+		{"getPropertiesByRange", "1", "999"},
+		{"getPropertiesByRange", "1", "999", "name"},
+		{"getPropertiesByOwnerIndexed", "tom"},
+	}
+	for _, query := range queries {
+		if keys := resultKeys(t, stub.invoke(agent, query[0], query[1:]...), query[0]); len(keys) != 2 {
+			t.Fatalf("%v returned %v, expected both properties", query, keys)
+		}
+	}
+
+	checkOK(t, stub.invoke(agent, "initProperty", "102", "house", "seoul jongno 3", "tom"), "initProperty")
+	for _, query := range queries {
+		checkErrorCode(t, stub.invoke(agent, query[0], query[1:]...), errCodeTooManyResults, fmt.Sprint(query))
+	}
+	stub.richQuery = false
+	checkErrorCode(t, stub.invoke(agent, "getAllRecordsByType", "property", "scan"), errCodeTooManyResults, "getAllRecordsByType with scan")
+
+	// handlers that aggregate over the conditions they query are capped the same way
+	stub.richQuery = true
+	tom := identity(t, "tom", "")
+	checkOK(t, stub.invoke(tom, "initConditon", "1", "100", "tom", "jerry", "5000", "KRW"), "initConditon")
+	checkOK(t, stub.invoke(tom, "initConditon", "2", "100", "tom", "jerry", "6000", "KRW"), "initConditon")
+	aggregations := [][]string{
+		{"getAggregateDepositByProperty", "100"},
+		{"getMarketStatsByOwner", "tom"},
+		{"getPendingApprovalsForParty", "tom"},
+		{"getContractsInvolvingParty", "tom"},
+	}
+	for _, query := range aggregations {
+		checkOK(t, stub.invoke(agent, query[0], query[1:]...), fmt.Sprint(query))
+	}
+	checkOK(t, stub.invoke(tom, "initConditon", "3", "100", "tom", "jerry", "7000", "KRW"), "initConditon")
+	for _, query := range aggregations {
+		checkErrorCode(t, stub.invoke(agent, query[0], query[1:]...), errCodeTooManyResults, fmt.Sprint(query))
+	}
+}